@@ -0,0 +1,52 @@
+#!/bin/bash
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+ROOT=$(cd $(dirname "${BASH_SOURCE}")/.. && pwd -P)
+MODULE=tkestack.io/gpu-admission
+APIS_PKG=${MODULE}/pkg/apis
+OUTPUT_PKG=${MODULE}/pkg/client
+GROUP_VERSIONS="gpu/v1alpha1"
+BOILERPLATE=${ROOT}/hack/boilerplate.go.txt
+
+# code generators of k8s.io/code-generator, which should be the same version as
+# k8s.io/client-go, are looked up in GOBIN or PATH
+GOBIN=${GOBIN:-$(go env GOPATH)/bin}
+export PATH=${GOBIN}:${PATH}
+
+# generators write files under GOPATH layout, so they are generated into a temporary
+# directory and copied back
+OUTPUT_BASE=$(mktemp -d)
+trap "rm -rf ${OUTPUT_BASE}" EXIT
+
+input_dirs=""
+for gv in ${GROUP_VERSIONS}; do
+  input_dirs+="${APIS_PKG}/${gv},"
+done
+input_dirs=${input_dirs%,}
+
+cd ${ROOT}
+
+echo "Generating deepcopy funcs"
+deepcopy-gen --input-dirs ${input_dirs} -O zz_generated.deepcopy \
+  --bounding-dirs ${APIS_PKG} --go-header-file ${BOILERPLATE} --output-base ${OUTPUT_BASE}
+
+echo "Generating clientset"
+client-gen --clientset-name versioned --input-base "" --input ${input_dirs} \
+  --output-package ${OUTPUT_PKG}/clientset --go-header-file ${BOILERPLATE} \
+  --output-base ${OUTPUT_BASE}
+
+echo "Generating listers"
+lister-gen --input-dirs ${input_dirs} --output-package ${OUTPUT_PKG}/listers \
+  --go-header-file ${BOILERPLATE} --output-base ${OUTPUT_BASE}
+
+echo "Generating informers"
+informer-gen --input-dirs ${input_dirs} \
+  --versioned-clientset-package ${OUTPUT_PKG}/clientset/versioned \
+  --listers-package ${OUTPUT_PKG}/listers --output-package ${OUTPUT_PKG}/informers \
+  --go-header-file ${BOILERPLATE} --output-base ${OUTPUT_BASE}
+
+rm -rf ${ROOT}/pkg/client
+cp -r ${OUTPUT_BASE}/${MODULE}/pkg/. ${ROOT}/pkg/
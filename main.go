@@ -17,11 +17,14 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/spf13/pflag"
@@ -31,19 +34,68 @@ import (
 	"k8s.io/component-base/logs"
 	"k8s.io/klog"
 
+	"tkestack.io/gpu-admission/pkg/audit"
+	"tkestack.io/gpu-admission/pkg/breaker"
+	"tkestack.io/gpu-admission/pkg/client/clientset/versioned"
+	"tkestack.io/gpu-admission/pkg/ctl"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/leader"
 	"tkestack.io/gpu-admission/pkg/predicate"
+	"tkestack.io/gpu-admission/pkg/replay"
 	"tkestack.io/gpu-admission/pkg/route"
+	"tkestack.io/gpu-admission/pkg/server"
+	"tkestack.io/gpu-admission/pkg/util"
 	"tkestack.io/gpu-admission/pkg/version/verflag"
 )
 
 var (
-	kubeconfig     string
-	masterURL      string
-	listenAddress  string
-	profileAddress string
+	kubeconfig            string
+	masterURL             string
+	listenAddress         string
+	profileAddress        string
+	coreOvercommitRatio   float64
+	memoryOvercommitRatio float64
+	reservationConfig     string
+	reservationTTL        time.Duration
+	allocationRecord      string
+	gpuNodeInventory      bool
+	leaderElection        leader.Config
+	leaderElect           bool
+	advertiseAddress      string
+	parallelism           int
+	percentageOfNodes     int
+	tlsCertFile           string
+	tlsPrivateKeyFile     string
+	clientCAFile          string
+	leaderCAFile          string
+	tokenAuthFile         string
+	shutdownTimeout       time.Duration
+	filterTimeout         time.Duration
+	apiFailurePolicy      string
+	apiBreaker            breaker.Config
+	auditLogPath          string
+	auditLogMaxSize       int64
+	auditLogMaxBackup     int
+)
+
+// maxAPIBreakerCooldown is the longest cooldown of the breaker around API server calls
+const maxAPIBreakerCooldown = time.Minute
+
+const (
+	// allocationRecordAnnotation records allocations only in pod annotations
+	allocationRecordAnnotation = "annotation"
+	// allocationRecordCRD records allocations in GPUAllocation objects as well
+	allocationRecordCRD = "crd"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(ctl.Run(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(replay.Run(os.Args[2:]))
+	}
+
 	addFlags(pflag.CommandLine)
 
 	logs.InitLogs()
@@ -52,6 +104,7 @@ func main() {
 	flag.CommandLine.Parse([]string{})
 	verflag.PrintAndExitIfRequested()
 
+	stopCtx := server.SignalContext()
 	router := httprouter.New()
 	route.AddVersion(router)
 
@@ -70,20 +123,190 @@ func main() {
 		klog.Fatalf("Error building kubernetes clientset: %s", err.Error())
 	}
 
-	gpuFilter, err := predicate.NewGPUFilter(kubeClient)
+	if err := device.ValidateOvercommitRatio(coreOvercommitRatio); err != nil {
+		klog.Fatalf("Invalid --core-overcommit-ratio: %v", err)
+	}
+	if err := device.ValidateOvercommitRatio(memoryOvercommitRatio); err != nil {
+		klog.Fatalf("Invalid --memory-overcommit-ratio: %v", err)
+	}
+	if parallelism < 1 {
+		klog.Fatalf("Invalid --parallelism %d, it must be at least 1", parallelism)
+	}
+	nodeInfoOptions := []device.Option{
+		device.WithOvercommitRatio(coreOvercommitRatio, memoryOvercommitRatio),
+	}
+	if reservationConfig != "" {
+		reservations, err := device.LoadReservationConfig(reservationConfig)
+		if err != nil {
+			klog.Fatalf("Failed to load reservation config: %s", err.Error())
+		}
+		nodeInfoOptions = append(nodeInfoOptions, device.WithReservations(reservations))
+	}
+
+	filterOptions := []predicate.Option{
+		predicate.WithNodeInfoOptions(nodeInfoOptions...),
+		predicate.WithReservationTTL(reservationTTL),
+		predicate.WithParallelism(parallelism),
+		predicate.WithFilterTimeout(filterTimeout),
+		predicate.WithPercentageOfNodesToScore(percentageOfNodes),
+	}
+	switch policy := predicate.FailurePolicy(apiFailurePolicy); policy {
+	case predicate.FailClosed, predicate.FailOpen:
+		if policy == predicate.FailOpen {
+			// pods bound by the scheduler itself would run without reservations
+			klog.Warningf("--api-failure-policy=%s requires the scheduler to delegate binding "+
+				"by \"bindVerb\": \"bind\" in the extender config", policy)
+		}
+		apiBreaker.MaxCooldown = maxAPIBreakerCooldown
+		filterOptions = append(filterOptions, predicate.WithFailurePolicy(policy, apiBreaker))
+	default:
+		klog.Fatalf("Unknown API failure policy %q", apiFailurePolicy)
+	}
+	var auditLogger *audit.Logger
+	if auditLogPath != "" {
+		auditLogger, err = audit.NewLogger(auditLogPath, auditLogMaxSize<<20, auditLogMaxBackup)
+		if err != nil {
+			klog.Fatalf("Failed to open audit log: %s", err.Error())
+		}
+		filterOptions = append(filterOptions, predicate.WithAuditLogger(auditLogger))
+	}
+	gpuClient, err := versioned.NewForConfig(clientCfg)
+	if err != nil {
+		klog.Fatalf("Error building gpu clientset: %s", err.Error())
+	}
+	switch allocationRecord {
+	case allocationRecordAnnotation:
+	case allocationRecordCRD:
+		filterOptions = append(filterOptions, predicate.WithGPUAllocationRecord(gpuClient))
+	default:
+		klog.Fatalf("Unknown allocation record %q", allocationRecord)
+	}
+	if gpuNodeInventory {
+		filterOptions = append(filterOptions, predicate.WithGPUNodeInventory(gpuClient))
+	}
+
+	gpuFilter, err := predicate.NewGPUFilter(kubeClient, filterOptions...)
 	if err != nil {
 		klog.Fatalf("Failed to new gpu quota filter: %s", err.Error())
 	}
-	route.AddPredicate(router, gpuFilter)
+	serverConfig := server.Config{
+		Address:          listenAddress,
+		CertFile:         tlsCertFile,
+		KeyFile:          tlsPrivateKeyFile,
+		ClientCAFile:     clientCAFile,
+		LeaderCAFile:     leaderCAFile,
+		TokenFile:        tokenAuthFile,
+		AlwaysAllowPaths: route.HealthPaths,
+	}
+	srv, err := server.New(serverConfig, router)
+	if err != nil {
+		klog.Fatalf("Failed to new server: %s", err.Error())
+	}
+	serverConfig.Address = profileAddress
+	serverConfig.AlwaysAllowPaths = nil
+	profileServer, err := server.New(serverConfig, http.DefaultServeMux)
+	if err != nil {
+		klog.Fatalf("Failed to new profile server: %s", err.Error())
+	}
+
+	// electorDone is closed after the Lease is released
+	electorCtx, stopElector := context.WithCancel(context.Background())
+	electorDone := make(chan struct{})
+	var leadership route.Leadership
+	if leaderElect {
+		leaderElection.Identity = advertiseAddress
+		if leaderElection.Identity == "" {
+			leaderElection.Identity = defaultIdentity(listenAddress)
+		}
+		// instances sharing an identity would all take the Lease as their own
+		if err := leader.ValidateIdentity(leaderElection.Identity); err != nil {
+			klog.Fatalf("Invalid --advertise-address for leader election: %v", err)
+		}
+		leaderElection.Handoff = gpuFilter.Handoff
+		elector, err := leader.NewElector(kubeClient, leaderElection)
+		if err != nil {
+			klog.Fatalf("Failed to new leader elector: %s", err.Error())
+		}
+		go func() {
+			defer close(electorDone)
+			elector.Run(electorCtx)
+		}()
+		leadership = elector
+	} else {
+		close(electorDone)
+	}
+
+	route.AddPredicate(router, gpuFilter, leadership, &route.Proxy{
+		Scheme:    srv.Scheme(),
+		Transport: srv.ProxyTransport(),
+	})
+	route.AddBind(router, gpuFilter, leadership, &route.Proxy{
+		Scheme:    srv.Scheme(),
+		Transport: srv.ProxyTransport(),
+	})
+	route.AddSimulate(router, gpuFilter)
+	route.AddInventory(router, gpuFilter)
+	route.AddFragmentation(router, gpuFilter)
+	route.AddMetrics(router, gpuFilter)
+	route.AddHealth(router, gpuFilter)
+
+	go func() {
+		if err := profileServer.ListenAndServe(); err != http.ErrServerClosed {
+			klog.Errorf("Profile server failed: %v", err)
+		}
+	}()
 
+	klog.Infof("Server starting on %s://%s", srv.Scheme(), listenAddress)
+	serveErr := make(chan error, 1)
 	go func() {
-		log.Println(http.ListenAndServe(profileAddress, nil))
+		serveErr <- srv.ListenAndServe()
 	}()
 
-	klog.Infof("Server starting on %s", listenAddress)
-	if err := http.ListenAndServe(listenAddress, router); err != nil {
-		log.Fatal(err)
+	var failed bool
+	select {
+	case err := <-serveErr:
+		klog.Errorf("Server failed: %v", err)
+		failed = true
+	case <-stopCtx.Done():
+	}
+
+	// finish in-flight requests before releasing the Lease, so the next leader
+	// observes all reservations made by this instance
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		klog.Errorf("Failed to finish in-flight requests: %v", err)
+	}
+	if err := profileServer.Shutdown(shutdownCtx); err != nil {
+		klog.Errorf("Failed to shut down profile server: %v", err)
+	}
+	stopElector()
+	<-electorDone
+	gpuFilter.Stop()
+	if auditLogger != nil {
+		if err := auditLogger.Close(); err != nil {
+			klog.Errorf("Failed to close audit log: %v", err)
+		}
+	}
+	klog.Infof("Server stopped")
+
+	if failed {
+		logs.FlushLogs()
+		os.Exit(1)
+	}
+}
+
+// defaultIdentity returns the hostname with the port of address
+func defaultIdentity(address string) string {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		klog.Fatalf("Invalid --address %s: %v", address, err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		klog.Fatalf("Failed to get hostname: %v", err)
 	}
+	return net.JoinHostPort(hostname, port)
 }
 
 func addFlags(fs *pflag.FlagSet) {
@@ -93,6 +316,72 @@ func addFlags(fs *pflag.FlagSet) {
 		"The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
 	fs.StringVar(&listenAddress, "address", "127.0.0.1:3456", "The address it will listen")
 	fs.StringVar(&profileAddress, "pprofAddress", "127.0.0.1:3457", "The address for debug")
+	fs.Float64Var(&coreOvercommitRatio, "core-overcommit-ratio", 1,
+		"The ratio from 1 to 10 GPU cores of each device are scaled by, if node is not labeled with "+
+			util.CoreOvercommitRatioLabel)
+	fs.Float64Var(&memoryOvercommitRatio, "memory-overcommit-ratio", 1,
+		"The ratio from 1 to 10 GPU memory of each device is scaled by, if node is not labeled with "+
+			util.MemoryOvercommitRatioLabel)
+	fs.StringVar(&reservationConfig, "reservation-config", "",
+		"Path to a JSON file declaring GPU devices reserved for system workloads")
+	fs.DurationVar(&reservationTTL, "reservation-ttl", 0,
+		"How long pods predicated but not bound hold their GPU devices, 0 means forever")
+	fs.StringVar(&allocationRecord, "allocation-record", allocationRecordAnnotation,
+		"Where allocations are recorded: annotation, or crd to record GPUAllocation objects "+
+			"besides the annotations and rebuild node state from them")
+	fs.BoolVar(&leaderElect, "leader-elect", false,
+		"Elect a leader by Lease before serving filter requests, standbys proxy them to the leader")
+	fs.StringVar(&leaderElection.Namespace, "leader-elect-namespace", "kube-system",
+		"The namespace of the Lease object used for leader election")
+	fs.StringVar(&leaderElection.Name, "leader-elect-name", "gpu-admission",
+		"The name of the Lease object used for leader election")
+	fs.DurationVar(&leaderElection.LeaseDuration, "leader-elect-lease-duration", 15*time.Second,
+		"The duration that standbys wait before taking over an unrenewed Lease")
+	fs.DurationVar(&leaderElection.RenewDeadline, "leader-elect-renew-deadline", 10*time.Second,
+		"The duration that the leader retries renewing the Lease before it stops leading")
+	fs.DurationVar(&leaderElection.RetryPeriod, "leader-elect-retry-period", 2*time.Second,
+		"The duration between attempts to acquire or renew the Lease")
+	fs.StringVar(&advertiseAddress, "advertise-address", "",
+		"The address where other instances reach this one, defaults to the hostname with the "+
+			"port of --address. It must be unique and routable if --leader-elect is set")
+	fs.IntVar(&parallelism, "parallelism", predicate.DefaultParallelism,
+		"The number of workers building and evaluating candidate nodes of a filter request")
+	fs.IntVar(&percentageOfNodes, "percentage-of-nodes-to-score", 100,
+		"The percentage of nodes to find feasible before the search of a filter request stops, "+
+			"0 means an adaptive percentage depending on the cluster size")
+	fs.StringVar(&tlsCertFile, "tls-cert-file", "",
+		"File containing the certificate to serve HTTPS, it's reloaded after rotation")
+	fs.StringVar(&tlsPrivateKeyFile, "tls-private-key-file", "",
+		"File containing the private key matching --tls-cert-file")
+	fs.StringVar(&clientCAFile, "client-ca-file", "",
+		"If set, requests presenting a client certificate signed by one of the authorities "+
+			"in this file are authenticated")
+	fs.StringVar(&leaderCAFile, "leader-ca-file", "",
+		"If set, the serving certificate of the leader which requests are proxied to is "+
+			"verified by the authorities in this file instead of the system roots")
+	fs.StringVar(&tokenAuthFile, "token-auth-file", "",
+		"If set, requests with one of the bearer tokens in this file, one per line, are authenticated")
+	fs.DurationVar(&filterTimeout, "filter-timeout", 0,
+		"The budget of each filter request, the reservation is released once it's exceeded, "+
+			"0 means no limit other than the scheduler's extender timeout")
+	fs.StringVar(&apiFailurePolicy, "api-failure-policy", string(predicate.FailClosed),
+		"How pods are filtered while the API server is degraded: fail-closed to fail all nodes, "+
+			"or fail-open to place pods without reservations, which are made by the bind endpoint")
+	fs.IntVar(&apiBreaker.FailureThreshold, "api-breaker-failure-threshold", 5,
+		"The number of consecutive API server failures which open the circuit breaker")
+	fs.DurationVar(&apiBreaker.Cooldown, "api-breaker-cooldown", 5*time.Second,
+		"How long the circuit breaker stays open before a trial call, doubled after each failed "+
+			"trial up to 1m")
+	fs.StringVar(&auditLogPath, "audit-log-path", "",
+		"If set, filter decisions are written to this file as JSON lines")
+	fs.Int64Var(&auditLogMaxSize, "audit-log-maxsize", 100,
+		"The maximum size in megabytes of the audit log before it's rotated")
+	fs.IntVar(&auditLogMaxBackup, "audit-log-maxbackup", 10,
+		"The maximum number of rotated audit log files to retain")
+	fs.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second,
+		"How long in-flight requests are waited for after receiving SIGTERM or SIGINT")
+	fs.BoolVar(&gpuNodeInventory, "gpu-node-inventory", false,
+		"Read GPU devices of nodes from GPUNode objects if they exist, instead of the node capacity")
 }
 
 func wordSepNormalizeFunc(f *pflag.FlagSet, name string) pflag.NormalizedName {
@@ -31,6 +31,8 @@ import (
 
 type allocator struct {
 	nodeInfo *device.NodeInfo
+	// hidden are the indexes of devices reserved for other pods
+	hidden map[int]bool
 }
 
 func NewAllocator(n *device.NodeInfo) *allocator {
@@ -39,6 +41,7 @@ func NewAllocator(n *device.NodeInfo) *allocator {
 
 // IsAllocatable attempt to allocate containers which has GPU request of given pod
 func (alloc *allocator) IsAllocatable(pod *v1.Pod) bool {
+	alloc.hidden = alloc.nodeInfo.ReservedDevicesFor(pod)
 	allocatable := true
 	for _, c := range pod.Spec.Containers {
 		if !util.IsGPURequiredContainer(&c) {
@@ -57,6 +60,7 @@ func (alloc *allocator) IsAllocatable(pod *v1.Pod) bool {
 // Allocate tries to find a suitable GPU device for containers
 // and records some data in pod's annotation
 func (alloc *allocator) Allocate(pod *v1.Pod) (*v1.Pod, error) {
+	alloc.hidden = alloc.nodeInfo.ReservedDevicesFor(pod)
 	newPod := pod.DeepCopy()
 	if newPod.Annotations == nil {
 		newPod.Annotations = make(map[string]string)
@@ -91,35 +95,48 @@ func (alloc *allocator) AllocateOne(container *v1.Container) ([]*device.DeviceIn
 		vcore, vmemory uint
 	)
 	node := alloc.nodeInfo.GetNode()
-	nodeTotalMemory := util.GetCapacityOfNode(node, util.VMemoryAnnotation)
-	deviceCount := util.GetGPUDeviceCountOfNode(node)
-	deviceTotalMemory := uint(nodeTotalMemory / deviceCount)
-	needCores := util.GetGPUResourceOfContainer(container, util.VCoreAnnotation)
+	deviceCount := alloc.nodeInfo.GetDeviceCount()
+	needCores := util.GetGPUCoresOfContainer(container)
 	needMemory := util.GetGPUResourceOfContainer(container, util.VMemoryAnnotation)
 
 	switch {
 	case needCores < util.HundredCore:
-		devs = NewShareMode(alloc.nodeInfo).Evaluate(needCores, needMemory)
+		devs = (&shareMode{node: alloc.nodeInfo, hidden: alloc.hidden}).
+			Evaluate(needCores, needMemory)
 		sharedMode = true
+		if len(devs) == 0 {
+			return nil, alloc.noFitDeviceError(container.Name, needCores, needMemory)
+		}
 	default:
-		devs = NewExclusiveMode(alloc.nodeInfo).Evaluate(needCores, needMemory)
-	}
-
-	if len(devs) == 0 {
-		return nil, fmt.Errorf("failed to allocate for container %s", container.Name)
-	}
-
-	if sharedMode {
-		vcore = needCores
-		vmemory = needMemory
-	} else {
-		vcore = util.HundredCore
-		vmemory = deviceTotalMemory
+		devs = (&exclusiveMode{node: alloc.nodeInfo, hidden: alloc.hidden}).
+			Evaluate(needCores, needMemory)
+		if len(devs) == 0 {
+			return nil, &InsufficientIdleDevicesError{
+				Container: container.Name,
+				Needed:    int(needCores / util.HundredCore),
+				Available: alloc.idleDeviceCount(),
+			}
+		}
 	}
 
 	// record this container GPU request, we don't rollback data if an error happened,
 	// because any container failed to be allocated will cause the predication failed
 	for _, dev := range devs {
+		if sharedMode {
+			vcore = needCores
+			vmemory = needMemory
+		} else {
+			// exclusive containers hold the whole device, including the overcommitted part
+			vcore = dev.TotalCores()
+			vmemory = dev.TotalMemory()
+		}
+		if dev.GetID() < 0 || dev.GetID() >= deviceCount {
+			return nil, &DeviceIndexOutOfRangeError{
+				Container:   container.Name,
+				Index:       dev.GetID(),
+				DeviceCount: deviceCount,
+			}
+		}
 		err := alloc.nodeInfo.AddUsedResources(dev.GetID(), vcore, vmemory)
 		if err != nil {
 			klog.Infof("failed to update used resource for node %s dev %d due to %v",
@@ -129,3 +146,41 @@ func (alloc *allocator) AllocateOne(container *v1.Container) ([]*device.DeviceIn
 	}
 	return devs, nil
 }
+
+// idleDeviceCount returns the number of devices which can be allocated exclusively
+func (alloc *allocator) idleDeviceCount() int {
+	var count int
+	for _, dev := range alloc.nodeInfo.GetDeviceMap() {
+		if alloc.available(dev) && dev.IsIdle() {
+			count++
+		}
+	}
+	return count
+}
+
+// noFitDeviceError returns the error with the maximum allocatable resources of
+// devices, which tells whether cores or memory is insufficient
+func (alloc *allocator) noFitDeviceError(container string, cores, memory uint) error {
+	err := &NoFitDeviceError{
+		Container: container,
+		Cores:     cores,
+		Memory:    memory,
+	}
+	for _, dev := range alloc.nodeInfo.GetDeviceMap() {
+		if !alloc.available(dev) {
+			continue
+		}
+		if dev.AllocatableCores() > err.MaxCores {
+			err.MaxCores = dev.AllocatableCores()
+		}
+		if dev.AllocatableCores() >= cores && dev.AllocatableMemory() > err.MaxMemory {
+			err.MaxMemory = dev.AllocatableMemory()
+		}
+	}
+	return err
+}
+
+// available tells if the device can be allocated to the pod
+func (alloc *allocator) available(dev *device.DeviceInfo) bool {
+	return dev.IsAllocatable() && !alloc.hidden[dev.GetID()]
+}
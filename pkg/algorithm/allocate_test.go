@@ -0,0 +1,165 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+	utiltesting "tkestack.io/gpu-admission/pkg/util/testing"
+)
+
+func newTestContainer(cores, memory string) *corev1.Container {
+	container := utiltesting.NewContainer("container-0", cores, memory)
+	return &container
+}
+
+func newTestNodeInfo() *device.NodeInfo {
+	pod := utiltesting.NewPod("test-ns", "pod-0", *newTestContainer("50", "1"))
+	pod.Annotations[util.PredicateGPUIndexPrefix+"0"] = "0"
+	return device.NewNodeInfo(utiltesting.NewNode("testnode", 2, 8), []*corev1.Pod{pod})
+}
+
+func TestAllocateOneErrors(t *testing.T) {
+	testCases := []struct {
+		container *corev1.Container
+		reason    string
+		message   string
+	}{
+		{
+			container: newTestContainer("200", "8"),
+			reason:    ReasonNoIdleDevice,
+			message:   "container container-0 needs 2 idle GPU devices, but only 1 available",
+		},
+		{
+			container: newTestContainer("60", "1"),
+			reason:    "",
+		},
+		{
+			container: newTestContainer("50", "5"),
+			reason:    ReasonInsufficientMemory,
+			message: "container container-0 needs 5 GPU memory, but at most 4 available " +
+				"on a device with 50 cores",
+		},
+	}
+
+	for _, cs := range testCases {
+		_, err := NewAllocator(newTestNodeInfo()).AllocateOne(cs.container)
+		if cs.reason == "" {
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("expect error %q, got nil", cs.message)
+			continue
+		}
+		if Reason(err) != cs.reason || err.Error() != cs.message {
+			t.Errorf("got error %q (%s), expect %q (%s)", err, Reason(err), cs.message, cs.reason)
+		}
+	}
+
+	alloc := NewAllocator(newTestNodeInfo())
+	alloc.AllocateOne(newTestContainer("100", "4"))
+	_, err := alloc.AllocateOne(newTestContainer("60", "1"))
+	if Reason(err) != ReasonInsufficientCores {
+		t.Errorf("got error %v, expect %s", err, ReasonInsufficientCores)
+	}
+}
+
+func TestAllocateWithReservation(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "testnode",
+			Labels: map[string]string{
+				util.ReservedGPUCountLabel: "1",
+			},
+		},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{
+				util.VCoreAnnotation:   resource.MustParse("200"),
+				util.VMemoryAnnotation: resource.MustParse("8"),
+			},
+		},
+	}
+	config := &device.ReservationConfig{
+		Namespaces: []string{"oncall"},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-0", Namespace: "test-ns"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{*newTestContainer("200", "8")},
+		},
+	}
+
+	nodeInfo := device.NewNodeInfo(node, nil, device.WithReservations(config))
+	_, err := NewAllocator(nodeInfo).Allocate(pod)
+	if Reason(err) != ReasonNoIdleDevice {
+		t.Fatalf("pod should not use the reserved device, got err %v", err)
+	}
+
+	pod.Spec.Containers = []corev1.Container{*newTestContainer("100", "4")}
+	newPod, err := NewAllocator(nodeInfo).Allocate(pod)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if newPod.Annotations[util.PredicateGPUIndexPrefix+"0"] != "0" {
+		t.Fatalf("device 1 should be reserved, got %v", newPod.Annotations)
+	}
+
+	pod.Namespace = "oncall"
+	if _, err := NewAllocator(nodeInfo).Allocate(pod); err != nil {
+		t.Fatalf("pod in allowed namespace should use the reserved device, got err %v", err)
+	}
+}
+
+func TestAllocateBesideUnpredicatedWholeGPU(t *testing.T) {
+	shared := utiltesting.NewPod("test-ns", "pod-shared", *newTestContainer("50", "2"))
+	shared.Annotations[util.PredicateGPUIndexPrefix+"0"] = "0"
+	// the device plugin may have given any idle device to the pod
+	whole := utiltesting.NewPod("test-ns", "pod-whole", corev1.Container{
+		Name: "container-0",
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{
+				util.NvidiaGPUResource: resource.MustParse("1"),
+			},
+		},
+	})
+	whole.Spec.NodeName = "testnode"
+	nodeInfo := device.NewNodeInfo(utiltesting.NewNode("testnode", 4, 16),
+		[]*corev1.Pod{shared, whole})
+
+	// only the device shared by vcuda pods can be allocated
+	pod := utiltesting.NewPod("test-ns", "pod-0", *newTestContainer("30", "1"))
+	newPod, err := NewAllocator(nodeInfo).Allocate(pod)
+	if err != nil || newPod.Annotations[util.PredicateGPUIndexPrefix+"0"] != "0" {
+		t.Fatalf("pod should be placed on device 0, got %v, err %v", newPod, err)
+	}
+	for _, cores := range []string{"60", "100"} {
+		pod := utiltesting.NewPod("test-ns", "pod-1", *newTestContainer(cores, "1"))
+		if newPod, err := NewAllocator(nodeInfo).Allocate(pod); err == nil {
+			t.Errorf("pod of %s cores should not be placed on idle devices, got %v", cores,
+				newPod.Annotations)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package algorithm
+
+import (
+	"fmt"
+)
+
+// short reasons of allocation errors, used to aggregate failures of nodes
+const (
+	ReasonNoIdleDevice       = "no idle GPU device"
+	ReasonInsufficientCores  = "insufficient GPU cores"
+	ReasonInsufficientMemory = "insufficient GPU memory"
+	ReasonIndexOutOfRange    = "GPU device index out of range"
+	ReasonUnknown            = "failed to allocate GPU"
+)
+
+// AllocationError tells why GPU devices can't be allocated for a container
+type AllocationError interface {
+	error
+	// Reason returns a short reason which can be aggregated among nodes
+	Reason() string
+}
+
+// InsufficientIdleDevicesError means there are not enough whole idle devices for an
+// exclusive request
+type InsufficientIdleDevicesError struct {
+	Container string
+	Needed    int
+	Available int
+}
+
+func (e *InsufficientIdleDevicesError) Error() string {
+	return fmt.Sprintf("container %s needs %d idle GPU devices, but only %d available",
+		e.Container, e.Needed, e.Available)
+}
+
+func (e *InsufficientIdleDevicesError) Reason() string {
+	return ReasonNoIdleDevice
+}
+
+// NoFitDeviceError means no single device has enough cores and memory for a shared
+// request
+type NoFitDeviceError struct {
+	Container string
+	Cores     uint
+	Memory    uint
+	// MaxCores is the maximum allocatable cores of a device
+	MaxCores uint
+	// MaxMemory is the maximum allocatable memory of a device which has enough cores
+	MaxMemory uint
+}
+
+func (e *NoFitDeviceError) Error() string {
+	if e.MaxCores < e.Cores {
+		return fmt.Sprintf("container %s needs %d GPU cores, but at most %d available on a device",
+			e.Container, e.Cores, e.MaxCores)
+	}
+	return fmt.Sprintf("container %s needs %d GPU memory, but at most %d available on a device "+
+		"with %d cores", e.Container, e.Memory, e.MaxMemory, e.Cores)
+}
+
+func (e *NoFitDeviceError) Reason() string {
+	if e.MaxCores < e.Cores {
+		return ReasonInsufficientCores
+	}
+	return ReasonInsufficientMemory
+}
+
+// DeviceIndexOutOfRangeError means the allocated device doesn't exist on the node
+type DeviceIndexOutOfRangeError struct {
+	Container   string
+	Index       int
+	DeviceCount int
+}
+
+func (e *DeviceIndexOutOfRangeError) Error() string {
+	return fmt.Sprintf("device index %d of container %s out of range, node has %d devices",
+		e.Index, e.Container, e.DeviceCount)
+}
+
+func (e *DeviceIndexOutOfRangeError) Reason() string {
+	return ReasonIndexOutOfRange
+}
+
+// Reason returns the short reason of an allocation error
+func Reason(err error) string {
+	if allocErr, ok := err.(AllocationError); ok {
+		return allocErr.Reason()
+	}
+	return ReasonUnknown
+}
@@ -27,6 +27,8 @@ import (
 
 type exclusiveMode struct {
 	node *device.NodeInfo
+	// hidden are the indexes of devices which can't be picked up
+	hidden map[int]bool
 }
 
 //NewExclusiveMode returns a new exclusiveMode struct.
@@ -37,7 +39,7 @@ type exclusiveMode struct {
 //Exclusive mode means GPU devices are not sharing, only one
 //application can use them.
 func NewExclusiveMode(n *device.NodeInfo) *exclusiveMode {
-	return &exclusiveMode{n}
+	return &exclusiveMode{node: n}
 }
 
 func (al *exclusiveMode) Evaluate(cores uint, _ uint) []*device.DeviceInfo {
@@ -62,7 +64,7 @@ func (al *exclusiveMode) Evaluate(cores uint, _ uint) []*device.DeviceInfo {
 		if num == 0 {
 			break
 		}
-		if dev.AllocatableCores() == util.HundredCore {
+		if dev.IsAllocatable() && !al.hidden[dev.GetID()] && dev.IsIdle() {
 			devs = append(devs, dev)
 			num -= 1
 			continue
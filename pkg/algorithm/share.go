@@ -26,6 +26,8 @@ import (
 
 type shareMode struct {
 	node *device.NodeInfo
+	// hidden are the indexes of devices which can't be picked up
+	hidden map[int]bool
 }
 
 //NewShareMode returns a new shareMode struct.
@@ -36,7 +38,7 @@ type shareMode struct {
 //Share mode means multiple application may share one GPU device which uses
 //GPU more efficiently.
 func NewShareMode(n *device.NodeInfo) *shareMode {
-	return &shareMode{n}
+	return &shareMode{node: n}
 }
 
 func (al *shareMode) Evaluate(cores uint, memory uint) []*device.DeviceInfo {
@@ -54,7 +56,8 @@ func (al *shareMode) Evaluate(cores uint, memory uint) []*device.DeviceInfo {
 	sorter.Sort(tmpStore)
 
 	for _, dev := range tmpStore {
-		if dev.AllocatableCores() >= cores && dev.AllocatableMemory() >= memory {
+		if dev.IsAllocatable() && !al.hidden[dev.GetID()] &&
+			dev.AllocatableCores() >= cores && dev.AllocatableMemory() >= memory {
 			klog.V(4).Infof("Pick up %d , cores: %d, memory: %d",
 				dev.GetID(), dev.AllocatableCores(), dev.AllocatableMemory())
 			devs = append(devs, dev)
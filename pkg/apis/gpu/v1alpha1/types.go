@@ -0,0 +1,139 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// GPUAllocation records the GPU devices allocated to a container of a pod. It's
+// owned by the pod, so it's removed together with the pod.
+type GPUAllocation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GPUAllocationSpec   `json:"spec"`
+	Status GPUAllocationStatus `json:"status,omitempty"`
+}
+
+// GPUAllocationSpec describes the devices allocated to a container
+type GPUAllocationSpec struct {
+	// PodName and PodUID identify the pod in the same namespace
+	PodName string    `json:"podName"`
+	PodUID  types.UID `json:"podUID"`
+	// Container is the name of the container, ContainerIndex is its index in pod spec
+	Container      string `json:"container"`
+	ContainerIndex int    `json:"containerIndex"`
+	// NodeName is the node which the pod is predicated to
+	NodeName string `json:"nodeName"`
+	// DeviceIndexes are the indexes of GPU devices allocated to the container
+	DeviceIndexes []int `json:"deviceIndexes"`
+	// Cores and Memory are the GPU cores and memory held on each device, in units of
+	// tencent.com/vcuda-core and tencent.com/vcuda-memory
+	Cores  int64 `json:"cores"`
+	Memory int64 `json:"memory"`
+}
+
+// GPUAllocationPhase is the phase of a GPUAllocation
+type GPUAllocationPhase string
+
+const (
+	// GPUAllocationReserved means the devices are reserved for the pod by predication
+	GPUAllocationReserved GPUAllocationPhase = "Reserved"
+)
+
+// GPUAllocationStatus is the status of a GPUAllocation
+type GPUAllocationStatus struct {
+	Phase GPUAllocationPhase `json:"phase,omitempty"`
+	// PredicateTime is when the devices are allocated
+	PredicateTime metav1.Time `json:"predicateTime,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// GPUAllocationList is a list of GPUAllocation
+type GPUAllocationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []GPUAllocation `json:"items"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// GPUNode describes the GPU devices of a node, it's named after the node and
+// published by gpu-manager or a node agent
+type GPUNode struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec GPUNodeSpec `json:"spec"`
+}
+
+// GPUNodeSpec describes the GPU devices of a node
+type GPUNodeSpec struct {
+	Devices []GPUDevice `json:"devices"`
+}
+
+// GPUDevice describes a GPU device and its topology
+type GPUDevice struct {
+	// Index is the index of device on the node, starting from 0
+	Index int    `json:"index"`
+	UUID  string `json:"uuid,omitempty"`
+	Model string `json:"model,omitempty"`
+	// Memory is the memory of device, in units of tencent.com/vcuda-memory
+	Memory int64 `json:"memory"`
+	// NUMANode is the NUMA node which the device is attached to
+	NUMANode int `json:"numaNode,omitempty"`
+	// Links are the connections to other devices on the node
+	Links  []GPULink       `json:"links,omitempty"`
+	Health GPUDeviceHealth `json:"health,omitempty"`
+}
+
+// GPULink is a connection between two devices
+type GPULink struct {
+	// Index is the index of the peer device
+	Index int `json:"index"`
+	// Type is the type of connection, e.g. NVLink, PCIe
+	Type string `json:"type"`
+}
+
+// GPUDeviceHealth tells if a device works well
+type GPUDeviceHealth string
+
+const (
+	// GPUDeviceHealthy means the device can be allocated
+	GPUDeviceHealthy GPUDeviceHealth = "Healthy"
+	// GPUDeviceUnhealthy means the device is broken, e.g. ECC errors
+	GPUDeviceUnhealthy GPUDeviceHealth = "Unhealthy"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// GPUNodeList is a list of GPUNode
+type GPUNodeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []GPUNode `json:"items"`
+}
@@ -0,0 +1,128 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"k8s.io/klog"
+)
+
+// Logger writes records as JSON lines to a file. The file is rotated once it exceeds
+// the max size, path.1 is the latest backup and at most maxBackups are kept.
+type Logger struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewLogger opens the file at path to append records
+func NewLogger(path string, maxSize int64, maxBackups int) (*Logger, error) {
+	l := &Logger{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// Log writes the record, failures are only logged as the decision has been made
+func (l *Logger) Log(record *Record) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		klog.Errorf("failed to marshal audit record of pod %s/%s: %v", record.Pod.Namespace,
+			record.Pod.Name, err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return
+	}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			klog.Errorf("failed to rotate audit log %s: %v", l.path, err)
+			if l.file == nil {
+				return
+			}
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		klog.Errorf("failed to write audit record of pod %s/%s: %v", record.Pod.Namespace,
+			record.Pod.Name, err)
+	}
+}
+
+// rotate renames the file to path.1 after shifting the backups, and opens a new one
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		klog.Errorf("failed to close audit log %s: %v", l.path, err)
+	}
+	l.file = nil
+	if l.maxBackups > 0 {
+		os.Remove(backupPath(l.path, l.maxBackups))
+		for i := l.maxBackups - 1; i >= 1; i-- {
+			os.Rename(backupPath(l.path, i), backupPath(l.path, i+1))
+		}
+		if err := os.Rename(l.path, backupPath(l.path, 1)); err != nil {
+			klog.Errorf("failed to back up audit log %s: %v", l.path, err)
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		klog.Errorf("failed to remove audit log %s: %v", l.path, err)
+	}
+	return l.open()
+}
+
+func backupPath(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// Close closes the file, records are dropped after closing
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,92 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readRecords(t *testing.T, path string) []Record {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid record in %s: %v", path, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLoggerRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	newRecord := func(i int) *Record {
+		return &Record{Pod: Pod{Namespace: "ns", Name: fmt.Sprintf("pod-%d", i)}, Result: "unfit"}
+	}
+	line, _ := json.Marshal(newRecord(0))
+	// every file holds 2 records
+	logger, err := NewLogger(path, int64(len(line)+1)*2, 2)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	for i := 0; i < 7; i++ {
+		logger.Log(newRecord(i))
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatalf("failed to close logger: %v", err)
+	}
+	// records written after closing are dropped
+	logger.Log(newRecord(7))
+
+	expected := map[string][]string{
+		path:                {"pod-6"},
+		backupPath(path, 1): {"pod-4", "pod-5"},
+		backupPath(path, 2): {"pod-2", "pod-3"},
+	}
+	for file, names := range expected {
+		records := readRecords(t, file)
+		if len(records) != len(names) {
+			t.Fatalf("expect %d records in %s, got %d", len(names), file, len(records))
+		}
+		for i, record := range records {
+			if record.Pod.Name != names[i] {
+				t.Errorf("expect %s in %s, got %s", names[i], file, record.Pod.Name)
+			}
+		}
+	}
+	if _, err := os.Stat(backupPath(path, 3)); !os.IsNotExist(err) {
+		t.Errorf("expect at most 2 backups, got %v", err)
+	}
+}
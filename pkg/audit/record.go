@@ -0,0 +1,142 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package audit
+
+import (
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// Record describes a filter decision, it tells why a pod is placed on the node and
+// devices, or why it isn't placed anywhere
+type Record struct {
+	Time time.Time `json:"time"`
+	Pod  Pod       `json:"pod"`
+	// PodGroup is the pod group which the pod is admitted with
+	PodGroup   string      `json:"podGroup,omitempty"`
+	Containers []Container `json:"containers"`
+	// Candidates are the GPU nodes evaluated with their state before the decision
+	Candidates []Candidate `json:"candidates,omitempty"`
+	// Order is the feasible nodes in the order they are preferred
+	Order []string `json:"order,omitempty"`
+	// Node is the chosen node, and Devices are the device indexes of each container
+	Node    string           `json:"node,omitempty"`
+	Devices map[string][]int `json:"devices,omitempty"`
+	// FailedNodes maps node name to the reason why it's not chosen
+	FailedNodes extenderv1.FailedNodesMap `json:"failedNodes,omitempty"`
+	// Result is predicated, unfit, deferred or error
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Pod identifies a pod
+type Pod struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	UID       string `json:"uid"`
+}
+
+// Container is the GPU request of a container
+type Container struct {
+	Name   string `json:"name"`
+	Cores  uint   `json:"cores"`
+	Memory uint   `json:"memory"`
+}
+
+// Candidate is the allocatable state of a node
+type Candidate struct {
+	Name              string   `json:"name"`
+	AllocatableCores  int      `json:"allocatableCores"`
+	AllocatableMemory int      `json:"allocatableMemory"`
+	Devices           []Device `json:"devices"`
+}
+
+// Device is the allocatable state of a device
+type Device struct {
+	Index             int    `json:"index"`
+	State             string `json:"state"`
+	AllocatableCores  uint   `json:"allocatableCores"`
+	AllocatableMemory uint   `json:"allocatableMemory"`
+}
+
+// NewRecord returns the record of pod with its GPU requests
+func NewRecord(pod *corev1.Pod) *Record {
+	record := &Record{
+		Time: time.Now(),
+		Pod: Pod{
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			UID:       string(pod.UID),
+		},
+		PodGroup: pod.Annotations[util.PodGroupAnnotation],
+	}
+	for i := range pod.Spec.Containers {
+		c := &pod.Spec.Containers[i]
+		if !util.IsGPURequiredContainer(c) {
+			continue
+		}
+		record.Containers = append(record.Containers, Container{
+			Name:   c.Name,
+			Cores:  util.GetGPUCoresOfContainer(c),
+			Memory: util.GetGPUResourceOfContainer(c, util.VMemoryAnnotation),
+		})
+	}
+	return record
+}
+
+// NewCandidate returns the allocatable state of a node
+func NewCandidate(nodeInfo *device.NodeInfo) Candidate {
+	candidate := Candidate{
+		Name:              nodeInfo.GetName(),
+		AllocatableCores:  nodeInfo.GetAvailableCore(),
+		AllocatableMemory: nodeInfo.GetAvailableMemory(),
+		Devices:           make([]Device, 0, nodeInfo.GetDeviceCount()),
+	}
+	for _, dev := range nodeInfo.GetDeviceMap() {
+		candidate.Devices = append(candidate.Devices, Device{
+			Index:             dev.GetID(),
+			State:             string(dev.GetState()),
+			AllocatableCores:  dev.AllocatableCores(),
+			AllocatableMemory: dev.AllocatableMemory(),
+		})
+	}
+	sort.Slice(candidate.Devices, func(i, j int) bool {
+		return candidate.Devices[i].Index < candidate.Devices[j].Index
+	})
+	return candidate
+}
+
+// SetPlacement records the chosen node and the devices of each GPU container, which
+// are read from the predication annotations of pod
+func (r *Record) SetPlacement(nodeName string, pod *corev1.Pod) {
+	r.Node = nodeName
+	r.Devices = make(map[string][]int)
+	for i, c := range pod.Spec.Containers {
+		if !util.IsGPURequiredContainer(&c) {
+			continue
+		}
+		if indexes, err := util.GetPredicateIdxOfContainer(pod, i); err == nil {
+			r.Devices[c.Name] = indexes
+		}
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// ErrOpen is returned instead of calling while the breaker is open
+var ErrOpen = errors.New("circuit breaker is open: the API server is degraded, retry later")
+
+// State is the state of a breaker
+type State int
+
+const (
+	// Closed lets all calls through
+	Closed State = iota
+	// HalfOpen lets one trial call through after the cooldown of Open
+	HalfOpen
+	// Open rejects all calls until the cooldown passes
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	}
+	return "unknown"
+}
+
+// Config configures a breaker
+type Config struct {
+	// FailureThreshold is the number of consecutive failures which open the breaker
+	FailureThreshold int
+	// Cooldown is how long the breaker stays open before a trial call, it's doubled
+	// each time the trial fails, up to MaxCooldown
+	Cooldown    time.Duration
+	MaxCooldown time.Duration
+	// OnStateChange is called with the new state after the state changes
+	OnStateChange func(State)
+}
+
+// Breaker stops calling a degraded dependency after consecutive failures, and lets a
+// trial call through after a cooldown to tell if it has recovered
+type Breaker struct {
+	config Config
+	// now returns the current time, it's replaced in tests
+	now func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	cooldown time.Duration
+	openedAt time.Time
+	// trial tells if the trial call of HalfOpen is in flight
+	trial bool
+}
+
+// New returns a closed breaker
+func New(config Config) *Breaker {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 1
+	}
+	if config.MaxCooldown < config.Cooldown {
+		config.MaxCooldown = config.Cooldown
+	}
+	return &Breaker{config: config, now: time.Now, cooldown: config.Cooldown}
+}
+
+// State returns the current state, Open turns to HalfOpen once the cooldown passes
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.checkCooldown()
+	return b.state
+}
+
+// Ready reports ErrOpen while the breaker is open
+func (b *Breaker) Ready() error {
+	if b.State() == Open {
+		return ErrOpen
+	}
+	return nil
+}
+
+// Allow tells if a call can be made, every allowed call should be followed by Done
+// with its result
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.checkCooldown()
+	switch b.state {
+	case Open:
+		return ErrOpen
+	case HalfOpen:
+		if b.trial {
+			return ErrOpen
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+// Done records the result of an allowed call, err should be nil unless the call
+// failed because of the dependency
+func (b *Breaker) Done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		if b.state != Closed {
+			b.cooldown = b.config.Cooldown
+			b.setState(Closed)
+		}
+		b.trial = false
+		return
+	}
+
+	b.failures++
+	switch {
+	case b.state == HalfOpen && b.trial:
+		// the dependency hasn't recovered, wait longer before the next trial
+		b.cooldown *= 2
+		if b.cooldown > b.config.MaxCooldown {
+			b.cooldown = b.config.MaxCooldown
+		}
+		b.trip(err)
+	case b.state == Closed && b.failures >= b.config.FailureThreshold:
+		b.trip(err)
+	}
+}
+
+func (b *Breaker) trip(err error) {
+	b.trial = false
+	b.openedAt = b.now()
+	klog.Infof("circuit breaker opened for %s after %d consecutive failures, last: %v",
+		b.cooldown, b.failures, err)
+	b.setState(Open)
+}
+
+func (b *Breaker) checkCooldown() {
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.cooldown {
+		b.setState(HalfOpen)
+	}
+}
+
+func (b *Breaker) setState(state State) {
+	if b.state == state {
+		return
+	}
+	klog.Infof("circuit breaker turned from %s to %s", b.state, state)
+	b.state = state
+	if b.config.OnStateChange != nil {
+		b.config.OnStateChange(state)
+	}
+}
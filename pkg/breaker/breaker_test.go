@@ -0,0 +1,100 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	var (
+		now     = time.Unix(0, 0)
+		states  []State
+		failure = errors.New("failure")
+	)
+	b := New(Config{
+		FailureThreshold: 2,
+		Cooldown:         time.Second,
+		MaxCooldown:      3 * time.Second,
+		OnStateChange: func(state State) {
+			states = append(states, state)
+		},
+	})
+	b.now = func() time.Time { return now }
+
+	call := func(err error) error {
+		if err := b.Allow(); err != nil {
+			return err
+		}
+		b.Done(err)
+		return nil
+	}
+
+	// opened after consecutive failures
+	call(failure)
+	if b.State() != Closed {
+		t.Fatalf("expect closed after 1 failure, got %s", b.State())
+	}
+	call(failure)
+	if b.State() != Open || b.Ready() != ErrOpen {
+		t.Fatalf("expect open after 2 failures, got %s", b.State())
+	}
+	if err := call(nil); err != ErrOpen {
+		t.Fatalf("expect calls rejected while open, got %v", err)
+	}
+
+	// only one trial after cooldown, the cooldown is doubled if it fails
+	now = now.Add(time.Second)
+	if b.State() != HalfOpen {
+		t.Fatalf("expect half-open after cooldown, got %s", b.State())
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expect trial allowed, got %v", err)
+	}
+	if err := b.Allow(); err != ErrOpen {
+		t.Fatalf("expect only one trial, got %v", err)
+	}
+	b.Done(failure)
+	now = now.Add(time.Second)
+	if b.State() != Open {
+		t.Fatalf("expect open during doubled cooldown, got %s", b.State())
+	}
+	now = now.Add(time.Second)
+	if b.State() != HalfOpen {
+		t.Fatalf("expect half-open after doubled cooldown, got %s", b.State())
+	}
+
+	// closed after the trial succeeds
+	if err := call(nil); err != nil {
+		t.Fatalf("expect trial allowed, got %v", err)
+	}
+	if b.State() != Closed || b.Ready() != nil {
+		t.Fatalf("expect closed after trial succeeded, got %s", b.State())
+	}
+
+	expected := []State{Open, HalfOpen, Open, HalfOpen, Closed}
+	if len(states) != len(expected) {
+		t.Fatalf("expect state changes %v, got %v", expected, states)
+	}
+	for i := range expected {
+		if states[i] != expected[i] {
+			t.Fatalf("expect state changes %v, got %v", expected, states)
+		}
+	}
+}
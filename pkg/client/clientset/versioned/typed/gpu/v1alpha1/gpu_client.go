@@ -0,0 +1,94 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	rest "k8s.io/client-go/rest"
+	v1alpha1 "tkestack.io/gpu-admission/pkg/apis/gpu/v1alpha1"
+	"tkestack.io/gpu-admission/pkg/client/clientset/versioned/scheme"
+)
+
+type GpuV1alpha1Interface interface {
+	RESTClient() rest.Interface
+	GPUAllocationsGetter
+	GPUNodesGetter
+}
+
+// GpuV1alpha1Client is used to interact with features provided by the gpu.tencent.com group.
+type GpuV1alpha1Client struct {
+	restClient rest.Interface
+}
+
+func (c *GpuV1alpha1Client) GPUAllocations(namespace string) GPUAllocationInterface {
+	return newGPUAllocations(c, namespace)
+}
+
+func (c *GpuV1alpha1Client) GPUNodes() GPUNodeInterface {
+	return newGPUNodes(c)
+}
+
+// NewForConfig creates a new GpuV1alpha1Client for the given config.
+func NewForConfig(c *rest.Config) (*GpuV1alpha1Client, error) {
+	config := *c
+	if err := setConfigDefaults(&config); err != nil {
+		return nil, err
+	}
+	client, err := rest.RESTClientFor(&config)
+	if err != nil {
+		return nil, err
+	}
+	return &GpuV1alpha1Client{client}, nil
+}
+
+// NewForConfigOrDie creates a new GpuV1alpha1Client for the given config and
+// panics if there is an error in the config.
+func NewForConfigOrDie(c *rest.Config) *GpuV1alpha1Client {
+	client, err := NewForConfig(c)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// New creates a new GpuV1alpha1Client for the given RESTClient.
+func New(c rest.Interface) *GpuV1alpha1Client {
+	return &GpuV1alpha1Client{c}
+}
+
+func setConfigDefaults(config *rest.Config) error {
+	gv := v1alpha1.SchemeGroupVersion
+	config.GroupVersion = &gv
+	config.APIPath = "/apis"
+	config.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
+
+	if config.UserAgent == "" {
+		config.UserAgent = rest.DefaultKubernetesUserAgent()
+	}
+
+	return nil
+}
+
+// RESTClient returns a RESTClient that is used to communicate
+// with API server by this client implementation.
+func (c *GpuV1alpha1Client) RESTClient() rest.Interface {
+	if c == nil {
+		return nil
+	}
+	return c.restClient
+}
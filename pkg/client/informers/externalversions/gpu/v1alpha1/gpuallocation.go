@@ -0,0 +1,90 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	"context"
+	time "time"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	cache "k8s.io/client-go/tools/cache"
+	gpuv1alpha1 "tkestack.io/gpu-admission/pkg/apis/gpu/v1alpha1"
+	versioned "tkestack.io/gpu-admission/pkg/client/clientset/versioned"
+	internalinterfaces "tkestack.io/gpu-admission/pkg/client/informers/externalversions/internalinterfaces"
+	v1alpha1 "tkestack.io/gpu-admission/pkg/client/listers/gpu/v1alpha1"
+)
+
+// GPUAllocationInformer provides access to a shared informer and lister for
+// GPUAllocations.
+type GPUAllocationInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() v1alpha1.GPUAllocationLister
+}
+
+type gPUAllocationInformer struct {
+	factory          internalinterfaces.SharedInformerFactory
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+	namespace        string
+}
+
+// NewGPUAllocationInformer constructs a new informer for GPUAllocation type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewGPUAllocationInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return NewFilteredGPUAllocationInformer(client, namespace, resyncPeriod, indexers, nil)
+}
+
+// NewFilteredGPUAllocationInformer constructs a new informer for GPUAllocation type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewFilteredGPUAllocationInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers, tweakListOptions internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.GpuV1alpha1().GPUAllocations(namespace).List(context.TODO(), options)
+			},
+			WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.GpuV1alpha1().GPUAllocations(namespace).Watch(context.TODO(), options)
+			},
+		},
+		&gpuv1alpha1.GPUAllocation{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+func (f *gPUAllocationInformer) defaultInformer(client versioned.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return NewFilteredGPUAllocationInformer(client, f.namespace, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+}
+
+func (f *gPUAllocationInformer) Informer() cache.SharedIndexInformer {
+	return f.factory.InformerFor(&gpuv1alpha1.GPUAllocation{}, f.defaultInformer)
+}
+
+func (f *gPUAllocationInformer) Lister() v1alpha1.GPUAllocationLister {
+	return v1alpha1.NewGPUAllocationLister(f.Informer().GetIndexer())
+}
@@ -0,0 +1,94 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	v1alpha1 "tkestack.io/gpu-admission/pkg/apis/gpu/v1alpha1"
+)
+
+// GPUAllocationLister helps list GPUAllocations.
+type GPUAllocationLister interface {
+	// List lists all GPUAllocations in the indexer.
+	List(selector labels.Selector) (ret []*v1alpha1.GPUAllocation, err error)
+	// GPUAllocations returns an object that can list and get GPUAllocations.
+	GPUAllocations(namespace string) GPUAllocationNamespaceLister
+	GPUAllocationListerExpansion
+}
+
+// gPUAllocationLister implements the GPUAllocationLister interface.
+type gPUAllocationLister struct {
+	indexer cache.Indexer
+}
+
+// NewGPUAllocationLister returns a new GPUAllocationLister.
+func NewGPUAllocationLister(indexer cache.Indexer) GPUAllocationLister {
+	return &gPUAllocationLister{indexer: indexer}
+}
+
+// List lists all GPUAllocations in the indexer.
+func (s *gPUAllocationLister) List(selector labels.Selector) (ret []*v1alpha1.GPUAllocation, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.GPUAllocation))
+	})
+	return ret, err
+}
+
+// GPUAllocations returns an object that can list and get GPUAllocations.
+func (s *gPUAllocationLister) GPUAllocations(namespace string) GPUAllocationNamespaceLister {
+	return gPUAllocationNamespaceLister{indexer: s.indexer, namespace: namespace}
+}
+
+// GPUAllocationNamespaceLister helps list and get GPUAllocations.
+type GPUAllocationNamespaceLister interface {
+	// List lists all GPUAllocations in the indexer for a given namespace.
+	List(selector labels.Selector) (ret []*v1alpha1.GPUAllocation, err error)
+	// Get retrieves the GPUAllocation from the indexer for a given namespace and name.
+	Get(name string) (*v1alpha1.GPUAllocation, error)
+	GPUAllocationNamespaceListerExpansion
+}
+
+// gPUAllocationNamespaceLister implements the GPUAllocationNamespaceLister
+// interface.
+type gPUAllocationNamespaceLister struct {
+	indexer   cache.Indexer
+	namespace string
+}
+
+// List lists all GPUAllocations in the indexer for a given namespace.
+func (s gPUAllocationNamespaceLister) List(selector labels.Selector) (ret []*v1alpha1.GPUAllocation, err error) {
+	err = cache.ListAllByNamespace(s.indexer, s.namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.GPUAllocation))
+	})
+	return ret, err
+}
+
+// Get retrieves the GPUAllocation from the indexer for a given namespace and name.
+func (s gPUAllocationNamespaceLister) Get(name string) (*v1alpha1.GPUAllocation, error) {
+	obj, exists, err := s.indexer.GetByKey(s.namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1alpha1.Resource("gpuallocation"), name)
+	}
+	return obj.(*v1alpha1.GPUAllocation), nil
+}
@@ -0,0 +1,65 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	v1alpha1 "tkestack.io/gpu-admission/pkg/apis/gpu/v1alpha1"
+)
+
+// GPUNodeLister helps list GPUNodes.
+type GPUNodeLister interface {
+	// List lists all GPUNodes in the indexer.
+	List(selector labels.Selector) (ret []*v1alpha1.GPUNode, err error)
+	// Get retrieves the GPUNode from the index for a given name.
+	Get(name string) (*v1alpha1.GPUNode, error)
+	GPUNodeListerExpansion
+}
+
+// gPUNodeLister implements the GPUNodeLister interface.
+type gPUNodeLister struct {
+	indexer cache.Indexer
+}
+
+// NewGPUNodeLister returns a new GPUNodeLister.
+func NewGPUNodeLister(indexer cache.Indexer) GPUNodeLister {
+	return &gPUNodeLister{indexer: indexer}
+}
+
+// List lists all GPUNodes in the indexer.
+func (s *gPUNodeLister) List(selector labels.Selector) (ret []*v1alpha1.GPUNode, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.GPUNode))
+	})
+	return ret, err
+}
+
+// Get retrieves the GPUNode from the index for a given name.
+func (s *gPUNodeLister) Get(name string) (*v1alpha1.GPUNode, error) {
+	obj, exists, err := s.indexer.GetByKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1alpha1.Resource("gpunode"), name)
+	}
+	return obj.(*v1alpha1.GPUNode), nil
+}
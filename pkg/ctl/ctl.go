@@ -0,0 +1,325 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package ctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	gpuv1alpha1 "tkestack.io/gpu-admission/pkg/apis/gpu/v1alpha1"
+	"tkestack.io/gpu-admission/pkg/client/clientset/versioned"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+const usage = `Inspect GPU allocations of the cluster.
+
+Usage:
+  gpu-admission ctl <command> [flags]
+
+Commands:
+  list            Print allocated cores/memory and owning pods of each GPU device
+  fragmentation   Print fragmentation metrics of each node and the cluster
+  defrag          Propose pod moves which would free --devices whole devices
+  inconsistent    Find pods with inconsistent GPU annotations
+  explain         Explain the GPU allocation of a pod, e.g. explain <namespace>/<name>
+  drain           Stop allocating a GPU device for maintenance, e.g. drain <node> <index>
+  undrain         Make a drained GPU device allocatable again, e.g. undrain <node> <index>
+
+Flags:
+`
+
+type options struct {
+	kubeconfig string
+	masterURL  string
+	selector   string
+	devices    int
+	// default overcommit ratios of nodes without labels
+	coreOvercommitRatio   float64
+	memoryOvercommitRatio float64
+	// reservationTTL is how long predicated but unbound pods hold their devices
+	reservationTTL    time.Duration
+	reservationConfig string
+	// allocationRecord and gpuNodeInventory tell if node state is built from the
+	// GPUAllocation and GPUNode objects read by gpuClient, like the extender does
+	allocationRecord string
+	gpuNodeInventory bool
+	gpuClient        versioned.Interface
+	out              io.Writer
+}
+
+const (
+	// allocationRecordAnnotation reads allocations only from pod annotations
+	allocationRecordAnnotation = "annotation"
+	// allocationRecordCRD reads allocations from GPUAllocation objects as well
+	allocationRecordCRD = "crd"
+)
+
+type command func(opts *options, client kubernetes.Interface, args []string) error
+
+var commands = map[string]command{
+	"list":          runList,
+	"fragmentation": runFragmentation,
+	"defrag":        runDefrag,
+	"inconsistent":  runInconsistent,
+	"explain":       runExplain,
+	"drain":         runDrain,
+	"undrain":       runUndrain,
+}
+
+// Run executes the ctl subcommand with given arguments and returns the exit code
+func Run(args []string) int {
+	opts := &options{out: os.Stdout}
+	fs := pflag.NewFlagSet("ctl", pflag.ContinueOnError)
+	fs.StringVar(&opts.kubeconfig, "kubeconfig", "",
+		"Path to a kubeconfig. Defaults to $KUBECONFIG or ~/.kube/config.")
+	fs.StringVar(&opts.masterURL, "master", "",
+		"The address of the Kubernetes API server. Overrides any value in kubeconfig.")
+	fs.StringVarP(&opts.selector, "selector", "l", "",
+		"Label selector to filter nodes")
+	fs.IntVar(&opts.devices, "devices", 1,
+		"Number of whole devices to free, used by defrag")
+	fs.Float64Var(&opts.coreOvercommitRatio, "core-overcommit-ratio", 1,
+		"The ratio from 1 to 10 GPU cores of each device are scaled by, if node is not labeled with "+
+			util.CoreOvercommitRatioLabel)
+	fs.Float64Var(&opts.memoryOvercommitRatio, "memory-overcommit-ratio", 1,
+		"The ratio from 1 to 10 GPU memory of each device is scaled by, if node is not labeled with "+
+			util.MemoryOvercommitRatioLabel)
+	fs.DurationVar(&opts.reservationTTL, "reservation-ttl", 0,
+		"How long pods predicated but not bound hold their GPU devices, 0 means forever")
+	fs.StringVar(&opts.reservationConfig, "reservation-config", "",
+		"Path to a JSON file declaring GPU devices reserved for system workloads")
+	fs.StringVar(&opts.allocationRecord, "allocation-record", allocationRecordAnnotation,
+		"Where allocations are read from: annotation, or crd to read GPUAllocation objects "+
+			"besides the annotations")
+	fs.BoolVar(&opts.gpuNodeInventory, "gpu-node-inventory", false,
+		"Read GPU devices of nodes from GPUNode objects if they exist, instead of the node capacity")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return 2
+	}
+
+	if err := device.ValidateOvercommitRatio(opts.coreOvercommitRatio); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --core-overcommit-ratio: %v\n", err)
+		return 2
+	}
+	if err := device.ValidateOvercommitRatio(opts.memoryOvercommitRatio); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --memory-overcommit-ratio: %v\n", err)
+		return 2
+	}
+
+	switch opts.allocationRecord {
+	case allocationRecordAnnotation, allocationRecordCRD:
+	default:
+		fmt.Fprintf(os.Stderr, "unknown allocation record %q\n", opts.allocationRecord)
+		return 2
+	}
+
+	clientCfg, err := newClientConfig(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to build kubeconfig: %v\n", err)
+		return 1
+	}
+	client, err := kubernetes.NewForConfig(clientCfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create kubernetes client: %v\n", err)
+		return 1
+	}
+	if opts.allocationRecord == allocationRecordCRD || opts.gpuNodeInventory {
+		opts.gpuClient, err = versioned.NewForConfig(clientCfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create gpu client: %v\n", err)
+			return 1
+		}
+	}
+	if err := cmd(opts, client, fs.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func newClientConfig(opts *options) (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = opts.kubeconfig
+	overrides := &clientcmd.ConfigOverrides{}
+	overrides.ClusterInfo.Server = opts.masterURL
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		loadingRules, overrides).ClientConfig()
+}
+
+// snapshot is the nodes and pods read from the API server
+type snapshot struct {
+	nodes           []*corev1.Node
+	pods            []*corev1.Pod
+	nodeInfoOptions []device.Option
+	// allocations are the GPUAllocation objects by node, and gpuNodes are the GPUNode
+	// objects by name, they are nil if not used
+	allocations map[string][]*gpuv1alpha1.GPUAllocation
+	gpuNodes    map[string]*gpuv1alpha1.GPUNode
+}
+
+func loadSnapshot(opts *options, client kubernetes.Interface, selector string) (*snapshot, error) {
+	nodeList, err := client.CoreV1().Nodes().List(context.Background(),
+		metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	podList, err := client.CoreV1().Pods(metav1.NamespaceAll).List(context.Background(),
+		metav1.ListOptions{
+			FieldSelector: fields.OneTermNotEqualSelector("status.phase",
+				string(corev1.PodSucceeded)).String(),
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %v", err)
+	}
+
+	s := &snapshot{
+		nodeInfoOptions: []device.Option{
+			device.WithOvercommitRatio(opts.coreOvercommitRatio, opts.memoryOvercommitRatio),
+			device.WithReservationTTL(opts.reservationTTL),
+		},
+	}
+	if opts.reservationConfig != "" {
+		reservations, err := device.LoadReservationConfig(opts.reservationConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load reservation config: %v", err)
+		}
+		s.nodeInfoOptions = append(s.nodeInfoOptions, device.WithReservations(reservations))
+	}
+	if opts.allocationRecord == allocationRecordCRD {
+		allocationList, err := opts.gpuClient.GpuV1alpha1().GPUAllocations(metav1.NamespaceAll).
+			List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list GPU allocations: %v", err)
+		}
+		s.allocations = make(map[string][]*gpuv1alpha1.GPUAllocation)
+		for i := range allocationList.Items {
+			alloc := &allocationList.Items[i]
+			nodeName := alloc.Labels[util.PredicateNode]
+			s.allocations[nodeName] = append(s.allocations[nodeName], alloc)
+		}
+	}
+	if opts.gpuNodeInventory {
+		gpuNodeList, err := opts.gpuClient.GpuV1alpha1().GPUNodes().List(context.Background(),
+			metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list GPU nodes: %v", err)
+		}
+		s.gpuNodes = make(map[string]*gpuv1alpha1.GPUNode)
+		for i := range gpuNodeList.Items {
+			s.gpuNodes[gpuNodeList.Items[i].Name] = &gpuNodeList.Items[i]
+		}
+	}
+	for i := range nodeList.Items {
+		s.nodes = append(s.nodes, &nodeList.Items[i])
+	}
+	sort.Slice(s.nodes, func(i, j int) bool {
+		return s.nodes[i].Name < s.nodes[j].Name
+	})
+	for i := range podList.Items {
+		s.pods = append(s.pods, &podList.Items[i])
+	}
+	return s, nil
+}
+
+// podsOnNode returns pods running on or predicated to given node
+func (s *snapshot) podsOnNode(nodeName string) []*corev1.Pod {
+	var ret []*corev1.Pod
+	for _, pod := range s.pods {
+		if util.IsPodOnNode(pod, nodeName) {
+			ret = append(ret, pod)
+		}
+	}
+	return ret
+}
+
+// nodeInfos builds the allocation state of GPU nodes sorted by name
+func (s *snapshot) nodeInfos() []*device.NodeInfo {
+	var ret []*device.NodeInfo
+	for _, node := range s.nodes {
+		if !util.IsGPUEnabledNode(node) {
+			continue
+		}
+		ret = append(ret, s.newNodeInfo(node))
+	}
+	return ret
+}
+
+// newNodeInfo builds the allocation state of node with the same options as the extender
+func (s *snapshot) newNodeInfo(node *corev1.Node) *device.NodeInfo {
+	opts := s.nodeInfoOptions[:len(s.nodeInfoOptions):len(s.nodeInfoOptions)]
+	if s.allocations != nil {
+		opts = append(opts, device.WithGPUAllocations(s.allocations[node.Name]))
+	}
+	if gpuNode, ok := s.gpuNodes[node.Name]; ok {
+		opts = append(opts, device.WithGPUNode(gpuNode))
+	}
+	return device.NewNodeInfo(node, s.podsOnNode(node.Name), opts...)
+}
+
+// sortedDevices returns the devices of node sorted by index
+func sortedDevices(nodeInfo *device.NodeInfo) []*device.DeviceInfo {
+	var devs []*device.DeviceInfo
+	for _, dev := range nodeInfo.GetDeviceMap() {
+		devs = append(devs, dev)
+	}
+	sort.Slice(devs, func(i, j int) bool {
+		return devs[i].GetID() < devs[j].GetID()
+	})
+	return devs
+}
+
+// owners formats the containers holding slices of the device
+func owners(dev *device.DeviceInfo) string {
+	var ret []string
+	for _, alloc := range dev.GetAllocations() {
+		ret = append(ret, fmt.Sprintf("%s/%s(%s)", alloc.Namespace, alloc.Pod, alloc.Container))
+	}
+	if len(ret) == 0 {
+		return "<none>"
+	}
+	sort.Strings(ret)
+	return strings.Join(ret, ",")
+}
@@ -0,0 +1,178 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package ctl
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	gpuv1alpha1 "tkestack.io/gpu-admission/pkg/apis/gpu/v1alpha1"
+	gpufake "tkestack.io/gpu-admission/pkg/client/clientset/versioned/fake"
+	"tkestack.io/gpu-admission/pkg/util"
+	utiltesting "tkestack.io/gpu-admission/pkg/util/testing"
+)
+
+func newTestNode(name string) *corev1.Node {
+	return utiltesting.NewNode(name, 2, 8)
+}
+
+func newTestPod(name, nodeName string, cores string, annotations map[string]string) *corev1.Pod {
+	pod := utiltesting.NewPod("test-ns", name, utiltesting.NewContainer("container-0", cores, "1"))
+	pod.Annotations = annotations
+	pod.Spec.NodeName = nodeName
+	return pod
+}
+
+func TestCheckPod(t *testing.T) {
+	nodes := map[string]*corev1.Node{"node0": newTestNode("node0")}
+	testCases := []struct {
+		pod     *corev1.Pod
+		problem string
+	}{
+		{
+			pod: newTestPod("consistent", "node0", "50", map[string]string{
+				util.PredicateNode:                 "node0",
+				util.PredicateGPUIndexPrefix + "0": "1",
+			}),
+		},
+		{
+			pod: newTestPod("wrong-node", "node1", "50", map[string]string{
+				util.PredicateNode:                 "node0",
+				util.PredicateGPUIndexPrefix + "0": "1",
+			}),
+			problem: "predicated to node0 but bound to node1",
+		},
+		{
+			pod: newTestPod("out-of-range", "", "50", map[string]string{
+				util.PredicateNode:                 "node0",
+				util.PredicateGPUIndexPrefix + "0": "2",
+			}),
+			problem: "device index 2 out of range",
+		},
+		{
+			pod: newTestPod("wrong-count", "", "200", map[string]string{
+				util.PredicateNode:                 "node0",
+				util.PredicateGPUIndexPrefix + "0": "0",
+			}),
+			problem: "got 1 devices, expect 2",
+		},
+		{
+			pod:     newTestPod("unpredicated", "node0", "50", nil),
+			problem: "bound without GPU predication",
+		},
+	}
+
+	for _, cs := range testCases {
+		problems := checkPod(cs.pod, nodes)
+		if cs.problem == "" {
+			if len(problems) != 0 {
+				t.Errorf("pod %s should be consistent, got %v", cs.pod.Name, problems)
+			}
+			continue
+		}
+		if !strings.Contains(strings.Join(problems, ";"), cs.problem) {
+			t.Errorf("pod %s should have problem %q, got %v", cs.pod.Name, cs.problem, problems)
+		}
+	}
+}
+
+func TestRunList(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestNode("node0"),
+		newTestPod("pod-0", "node0", "50", map[string]string{
+			util.PredicateNode:                 "node0",
+			util.PredicateGPUIndexPrefix + "0": "1",
+		}))
+	out := &bytes.Buffer{}
+
+	if err := runList(&options{out: out}, client, nil); err != nil {
+		t.Fatalf("list return err: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expect 1 header and 2 devices, got:\n%s", out.String())
+	}
+	if !strings.Contains(lines[2], "50/100") || !strings.Contains(lines[2], "test-ns/pod-0(container-0)") {
+		t.Fatalf("wrong allocation of device 1: %s", lines[2])
+	}
+}
+
+func TestRunListGPUAllocation(t *testing.T) {
+	pod := newTestPod("pod-0", "node0", "50", map[string]string{
+		util.PredicateNode:                 "node0",
+		util.PredicateGPUIndexPrefix + "0": "1",
+	})
+	pod.UID = "uid-0"
+	client := fake.NewSimpleClientset(newTestNode("node0"), pod)
+	// the GPUAllocation object takes precedence over the annotation
+	gpuClient := gpufake.NewSimpleClientset(&gpuv1alpha1.GPUAllocation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod-0-0",
+			Namespace: "test-ns",
+			Labels:    map[string]string{util.PredicateNode: "node0"},
+		},
+		Spec: gpuv1alpha1.GPUAllocationSpec{
+			PodName:       "pod-0",
+			PodUID:        "uid-0",
+			Container:     "container-0",
+			NodeName:      "node0",
+			DeviceIndexes: []int{0},
+		},
+	})
+	out := &bytes.Buffer{}
+
+	opts := &options{out: out, allocationRecord: allocationRecordCRD, gpuClient: gpuClient}
+	if err := runList(opts, client, nil); err != nil {
+		t.Fatalf("list return err: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expect 1 header and 2 devices, got:\n%s", out.String())
+	}
+	if !strings.Contains(lines[1], "test-ns/pod-0(container-0)") || strings.Contains(lines[2], "pod-0") {
+		t.Fatalf("pod-0 should be allocated device 0 by GPUAllocation:\n%s", out.String())
+	}
+}
+
+func TestRunDrain(t *testing.T) {
+	client := fake.NewSimpleClientset(newTestNode("node0"))
+	out := &bytes.Buffer{}
+
+	if err := runDrain(&options{out: out}, client, []string{"node0", "1"}); err != nil {
+		t.Fatalf("drain return err: %v", err)
+	}
+	if err := runDrain(&options{out: out}, client, []string{"node0", "2"}); err == nil {
+		t.Fatalf("drain should fail for out of range device")
+	}
+	node, _ := client.CoreV1().Nodes().Get(context.Background(), "node0", metav1.GetOptions{})
+	if node.Annotations[util.DrainedGPUIndexAnnotation] != "1" {
+		t.Fatalf("wrong drained devices: %v", node.Annotations)
+	}
+
+	if err := runUndrain(&options{out: out}, client, []string{"node0", "1"}); err != nil {
+		t.Fatalf("undrain return err: %v", err)
+	}
+	node, _ = client.CoreV1().Nodes().Get(context.Background(), "node0", metav1.GetOptions{})
+	if _, ok := node.Annotations[util.DrainedGPUIndexAnnotation]; ok {
+		t.Fatalf("drained annotation should be removed: %v", node.Annotations)
+	}
+}
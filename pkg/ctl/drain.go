@@ -0,0 +1,111 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package ctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// runDrain marks a GPU device of node as drained, so it will not be allocated to
+// new containers
+func runDrain(opts *options, client kubernetes.Interface, args []string) error {
+	return updateDrainedDevice(opts, client, args, true)
+}
+
+// runUndrain makes a drained GPU device of node allocatable again
+func runUndrain(opts *options, client kubernetes.Interface, args []string) error {
+	return updateDrainedDevice(opts, client, args, false)
+}
+
+func updateDrainedDevice(opts *options, client kubernetes.Interface, args []string,
+	drain bool) error {
+	if len(args) != 2 {
+		return fmt.Errorf("requires exactly two arguments <node> <device index>")
+	}
+	nodeName := args[0]
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid device index %s: %v", args[1], err)
+	}
+
+	node, err := client.CoreV1().Nodes().Get(context.Background(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if index < 0 || index >= util.GetGPUDeviceCountOfNode(node) {
+		return fmt.Errorf("device index %d out of range, node %s has %d devices",
+			index, nodeName, util.GetGPUDeviceCountOfNode(node))
+	}
+	indexes, err := util.ParseDeviceIndexes(node.Annotations[util.DrainedGPUIndexAnnotation])
+	if err != nil {
+		return fmt.Errorf("invalid annotation %s of node %s: %v",
+			util.DrainedGPUIndexAnnotation, nodeName, err)
+	}
+
+	drained := make(map[int]bool)
+	for _, i := range indexes {
+		drained[i] = true
+	}
+	drained[index] = drain
+	var ids []int
+	for i, ok := range drained {
+		if ok {
+			ids = append(ids, i)
+		}
+	}
+	sort.Ints(ids)
+	var value []string
+	for _, i := range ids {
+		value = append(value, strconv.Itoa(i))
+	}
+
+	// a null value removes the annotation
+	var annotation interface{}
+	if len(value) > 0 {
+		annotation = strings.Join(value, ",")
+	}
+	payload := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				util.DrainedGPUIndexAnnotation: annotation,
+			},
+		},
+	}
+	payloadBytes, _ := json.Marshal(payload)
+	if _, err := client.CoreV1().Nodes().Patch(context.Background(), nodeName,
+		k8stypes.StrategicMergePatchType, payloadBytes, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+
+	action := "drained"
+	if !drain {
+		action = "undrained"
+	}
+	fmt.Fprintf(opts.out, "device %d of node %s %s\n", index, nodeName, action)
+	return nil
+}
@@ -0,0 +1,121 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package ctl
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// runExplain prints the GPU allocation of a single pod and the devices it holds
+func runExplain(opts *options, client kubernetes.Interface, args []string) error {
+	// #lizard forgives
+	if len(args) != 1 {
+		return fmt.Errorf("explain requires exactly one argument <namespace>/<name>")
+	}
+	namespace, name := metav1.NamespaceDefault, args[0]
+	if parts := strings.SplitN(args[0], "/", 2); len(parts) == 2 {
+		namespace, name = parts[0], parts[1]
+	}
+
+	pod, err := client.CoreV1().Pods(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	s, err := loadSnapshot(opts, client, "")
+	if err != nil {
+		return err
+	}
+	nodes := make(map[string]*corev1.Node)
+	for _, node := range s.nodes {
+		nodes[node.Name] = node
+	}
+
+	w := tabwriter.NewWriter(opts.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Pod:\t%s/%s\n", pod.Namespace, pod.Name)
+	fmt.Fprintf(w, "Node:\t%s\n", podNodeName(pod))
+	fmt.Fprintf(w, "Bound:\t%t\n", pod.Spec.NodeName != "")
+	if v, ok := pod.Annotations[util.PredicateTimeAnnotation]; ok {
+		if nano, err := strconv.ParseInt(v, 10, 64); err == nil {
+			v = time.Unix(0, nano).Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "Predicated:\t%s\n", v)
+	}
+	if v, ok := pod.Annotations[util.GPUAssigned]; ok {
+		fmt.Fprintf(w, "Assigned:\t%s\n", v)
+	}
+	if !util.IsGPURequiredPod(pod) {
+		fmt.Fprintln(w, "Pod does not request GPU resource")
+		return w.Flush()
+	}
+
+	fmt.Fprintln(w, "Containers:")
+	fmt.Fprintln(w, "  NAME\tCORES\tMEMORY\tMODE\tDEVICES")
+	deviceIDs := make(map[int]bool)
+	for i, c := range pod.Spec.Containers {
+		cores := util.GetGPUCoresOfContainer(&c)
+		mode := "shared"
+		switch {
+		case !util.IsGPURequiredContainer(&c):
+			mode = "none"
+		case cores >= util.HundredCore:
+			mode = "exclusive"
+		}
+		devices := "<none>"
+		if indexes, err := util.GetPredicateIdxOfContainer(pod, i); err == nil {
+			var ids []string
+			for _, index := range indexes {
+				ids = append(ids, strconv.Itoa(index))
+				deviceIDs[index] = true
+			}
+			devices = strings.Join(ids, ",")
+		}
+		fmt.Fprintf(w, "  %s\t%d\t%d\t%s\t%s\n", c.Name, cores,
+			util.GetGPUResourceOfContainer(&c, util.VMemoryAnnotation), mode, devices)
+	}
+
+	if node, ok := nodes[podNodeName(pod)]; ok && util.IsGPUEnabledNode(node) {
+		nodeInfo := s.newNodeInfo(node)
+		fmt.Fprintf(w, "Devices on %s:\n", node.Name)
+		fmt.Fprintln(w, "  DEVICE\tCORES(USED/TOTAL)\tMEMORY(USED/TOTAL)\tPODS")
+		for _, dev := range sortedDevices(nodeInfo) {
+			if !deviceIDs[dev.GetID()] {
+				continue
+			}
+			fmt.Fprintf(w, "  %d\t%d/%d\t%d/%d\t%s\n", dev.GetID(), dev.UsedCores(),
+				dev.TotalCores(), dev.UsedMemory(), dev.TotalMemory(), owners(dev))
+		}
+	}
+
+	if problems := checkPod(pod, nodes); len(problems) > 0 {
+		fmt.Fprintln(w, "Problems:")
+		for _, problem := range problems {
+			fmt.Fprintf(w, "  %s\n", problem)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,79 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package ctl
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"k8s.io/client-go/kubernetes"
+
+	"tkestack.io/gpu-admission/pkg/fragmentation"
+)
+
+// runFragmentation prints the fragmentation metrics of each node and the cluster
+func runFragmentation(opts *options, client kubernetes.Interface, _ []string) error {
+	s, err := loadSnapshot(opts, client, opts.selector)
+	if err != nil {
+		return err
+	}
+	report := fragmentation.Analyze(s.nodeInfos())
+
+	w := tabwriter.NewWriter(opts.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tDEVICES\tIDLE\tPARTIAL\tFULL\tUNALLOCATABLE\t"+
+		"STRANDED-CORES\tSTRANDED-MEMORY")
+	for _, node := range report.Nodes {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n", node.Name, node.TotalDevices,
+			node.IdleDevices, node.PartialDevices, node.FullDevices, node.UnallocatableDevices,
+			node.StrandedCores, node.StrandedMemory)
+	}
+	fmt.Fprintf(w, "TOTAL\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n", report.TotalDevices,
+		report.IdleDevices, report.PartialDevices, report.FullDevices,
+		report.UnallocatableDevices, report.StrandedCores, report.StrandedMemory)
+	fmt.Fprintf(w, "\nWhole devices obtainable after defragmentation: %d\n",
+		report.ObtainableDevices)
+	fmt.Fprintf(w, "Stranded memory ratio: %.2f\n", report.MemoryFragmentation)
+	return w.Flush()
+}
+
+// runDefrag prints the pod moves which would free the number of whole devices
+// given by --devices
+func runDefrag(opts *options, client kubernetes.Interface, _ []string) error {
+	if opts.devices <= 0 {
+		return fmt.Errorf("--devices should be a positive integer")
+	}
+	s, err := loadSnapshot(opts, client, opts.selector)
+	if err != nil {
+		return err
+	}
+	plan := fragmentation.Recommend(s.nodeInfos(), opts.devices)
+
+	w := tabwriter.NewWriter(opts.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tPOD\tCONTAINER\tFROM\tTO")
+	for _, move := range plan.Moves {
+		for _, c := range move.Containers {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s/%d\t%s/%d\n", move.Namespace, move.Pod,
+				c.Container, move.FromNode, c.FromDevice, move.ToNode, c.ToDevice)
+		}
+	}
+	fmt.Fprintf(w, "\n%d of %d requested devices would be freed:", plan.Freed, plan.Requested)
+	for _, dev := range plan.Devices {
+		fmt.Fprintf(w, " %s/%d", dev.Node, dev.Index)
+	}
+	fmt.Fprintln(w)
+	return w.Flush()
+}
@@ -0,0 +1,149 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package ctl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// runInconsistent prints pods whose GPU annotations don't match their spec or node
+func runInconsistent(opts *options, client kubernetes.Interface, _ []string) error {
+	s, err := loadSnapshot(opts, client, "")
+	if err != nil {
+		return err
+	}
+	nodes := make(map[string]*corev1.Node)
+	for _, node := range s.nodes {
+		nodes[node.Name] = node
+	}
+
+	pods := append([]*corev1.Pod(nil), s.pods...)
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+		return pods[i].Name < pods[j].Name
+	})
+
+	w := tabwriter.NewWriter(opts.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tNAME\tNODE\tPROBLEM")
+	for _, pod := range pods {
+		for _, problem := range checkPod(pod, nodes) {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", pod.Namespace, pod.Name, podNodeName(pod), problem)
+		}
+	}
+	return w.Flush()
+}
+
+// podNodeName returns the node which the pod is bound or predicated to
+func podNodeName(pod *corev1.Pod) string {
+	if pod.Spec.NodeName != "" {
+		return pod.Spec.NodeName
+	}
+	if nodeName, ok := pod.Annotations[util.PredicateNode]; ok {
+		return nodeName
+	}
+	return "<none>"
+}
+
+// hasPredicateAnnotations tell if the pod has any annotation written by predication
+func hasPredicateAnnotations(pod *corev1.Pod) bool {
+	for k := range pod.Annotations {
+		if strings.Contains(k, util.GPUAssigned) ||
+			strings.Contains(k, util.PredicateTimeAnnotation) ||
+			strings.Contains(k, util.PredicateGPUIndexPrefix) ||
+			strings.Contains(k, util.PredicateNode) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPod returns the problems of GPU annotations of given pod
+func checkPod(pod *corev1.Pod, nodes map[string]*corev1.Node) []string {
+	// #lizard forgives
+	var problems []string
+	gpuRequired := util.IsGPURequiredPod(pod)
+	predicated := hasPredicateAnnotations(pod)
+
+	switch {
+	case !gpuRequired && predicated:
+		return []string{"has GPU annotations but requests no GPU"}
+	case !gpuRequired:
+		return nil
+	case !predicated:
+		if pod.Spec.NodeName == "" {
+			return nil
+		}
+		for _, c := range pod.Spec.Containers {
+			if util.IsGPURequiredContainer(&c) && !util.IsWholeGPUContainer(&c) {
+				return []string{"bound without GPU predication"}
+			}
+		}
+		return nil
+	}
+
+	predicateNode, ok := pod.Annotations[util.PredicateNode]
+	if !ok {
+		problems = append(problems, fmt.Sprintf("missing annotation %s", util.PredicateNode))
+	} else if pod.Spec.NodeName != "" && pod.Spec.NodeName != predicateNode {
+		problems = append(problems, fmt.Sprintf("predicated to %s but bound to %s",
+			predicateNode, pod.Spec.NodeName))
+	}
+
+	deviceCount := -1
+	if node, ok := nodes[podNodeName(pod)]; ok {
+		deviceCount = util.GetGPUDeviceCountOfNode(node)
+	} else {
+		problems = append(problems, fmt.Sprintf("node %s not found", podNodeName(pod)))
+	}
+
+	for i, c := range pod.Spec.Containers {
+		if !util.IsGPURequiredContainer(&c) {
+			continue
+		}
+		indexes, err := util.GetPredicateIdxOfContainer(pod, i)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("container %s: %v", c.Name, err))
+			continue
+		}
+		expect := 1
+		if cores := util.GetGPUCoresOfContainer(&c); cores >= util.HundredCore {
+			expect = int(cores / util.HundredCore)
+		}
+		if len(indexes) != expect {
+			problems = append(problems, fmt.Sprintf("container %s: got %d devices, expect %d",
+				c.Name, len(indexes), expect))
+		}
+		for _, index := range indexes {
+			if deviceCount >= 0 && (index < 0 || index >= deviceCount) {
+				problems = append(problems, fmt.Sprintf(
+					"container %s: device index %d out of range, node has %d devices",
+					c.Name, index, deviceCount))
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,43 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package ctl
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// runList prints the allocated cores/memory and owning pods of each GPU device
+func runList(opts *options, client kubernetes.Interface, _ []string) error {
+	s, err := loadSnapshot(opts, client, opts.selector)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(opts.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tDEVICE\tSTATE\tCORES(USED/TOTAL)\tMEMORY(USED/TOTAL)\tPODS")
+	for _, nodeInfo := range s.nodeInfos() {
+		for _, dev := range sortedDevices(nodeInfo) {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%d/%d\t%d/%d\t%s\n", nodeInfo.GetName(), dev.GetID(),
+				dev.GetState(), dev.UsedCores(), dev.TotalCores(), dev.UsedMemory(),
+				dev.TotalMemory(), owners(dev))
+		}
+	}
+	return w.Flush()
+}
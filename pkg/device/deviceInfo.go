@@ -18,21 +18,63 @@ package device
 
 import (
 	"fmt"
-
-	"tkestack.io/gpu-admission/pkg/util"
 )
 
 type DeviceInfo struct {
 	id          int
+	totalCore   uint
 	totalMemory uint
 	usedMemory  uint
 	usedCore    uint
+	allocations []Allocation
+	// state tells if this device can be allocated
+	state DeviceState
+	// uuid, model, numaNode and links are known only if the node publishes its
+	// devices by GPUNode
+	uuid     string
+	model    string
+	numaNode int
+	links    []Link
+}
+
+// Link is a connection from a device to another one on the same node
+type Link struct {
+	// Index is the index of the peer device
+	Index int
+	// Type is the type of connection, e.g. NVLink, PCIe
+	Type string
 }
 
-func newDeviceInfo(id int, totalMemory uint) *DeviceInfo {
+// DeviceState tells if a device can be allocated
+type DeviceState string
+
+const (
+	// DeviceAllocatable means the device can be allocated
+	DeviceAllocatable DeviceState = "Allocatable"
+	// DeviceUnhealthy means the device is broken, e.g. ECC errors
+	DeviceUnhealthy DeviceState = "Unhealthy"
+	// DeviceDrained means the device is drained by operators for maintenance
+	DeviceDrained DeviceState = "Drained"
+	// DeviceUnknown means the device may be held by a whole-GPU container placed
+	// without predication, so it's not allocated
+	DeviceUnknown DeviceState = "Unknown"
+)
+
+// Allocation represents a slice of GPU device held by a container
+type Allocation struct {
+	Namespace string
+	Pod       string
+	Container string
+	Cores     uint
+	Memory    uint
+}
+
+func newDeviceInfo(id int, totalCore uint, totalMemory uint) *DeviceInfo {
 	return &DeviceInfo{
 		id:          id,
+		totalCore:   totalCore,
 		totalMemory: totalMemory,
+		state:       DeviceAllocatable,
 	}
 }
 
@@ -41,9 +83,38 @@ func (dev *DeviceInfo) GetID() int {
 	return dev.id
 }
 
+// GetUUID returns the UUID of this device, it's empty if unknown
+func (dev *DeviceInfo) GetUUID() string {
+	return dev.uuid
+}
+
+// GetModel returns the model of this device, it's empty if unknown
+func (dev *DeviceInfo) GetModel() string {
+	return dev.model
+}
+
+// GetNUMANode returns the NUMA node which this device is attached to
+func (dev *DeviceInfo) GetNUMANode() int {
+	return dev.numaNode
+}
+
+// GetLinks returns the connections from this device to other ones
+func (dev *DeviceInfo) GetLinks() []Link {
+	return dev.links
+}
+
+// GetAllocations returns the slices of this device held by containers
+func (dev *DeviceInfo) GetAllocations() []Allocation {
+	return dev.allocations
+}
+
+func (dev *DeviceInfo) addAllocation(alloc Allocation) {
+	dev.allocations = append(dev.allocations, alloc)
+}
+
 // AddUsedResources records the used GPU core and memory
 func (dev *DeviceInfo) AddUsedResources(usedCore uint, usedMemory uint) error {
-	if usedCore+dev.usedCore > util.HundredCore {
+	if usedCore+dev.usedCore > dev.totalCore {
 		return fmt.Errorf("update usedcore failed, request: %d, already used: %d",
 			usedCore, dev.usedCore)
 	}
@@ -61,10 +132,47 @@ func (dev *DeviceInfo) AddUsedResources(usedCore uint, usedMemory uint) error {
 
 // AllocatableCores returns the remaining cores of this GPU device
 func (d *DeviceInfo) AllocatableCores() uint {
-	return util.HundredCore - d.usedCore
+	return d.totalCore - d.usedCore
 }
 
 // AllocatableMemory returns the remaining memory of this GPU device
 func (d *DeviceInfo) AllocatableMemory() uint {
 	return d.totalMemory - d.usedMemory
 }
+
+// TotalCores returns the total cores of this GPU device, including overcommitted ones
+func (d *DeviceInfo) TotalCores() uint {
+	return d.totalCore
+}
+
+// TotalMemory returns the total memory of this GPU device, including overcommitted one
+func (d *DeviceInfo) TotalMemory() uint {
+	return d.totalMemory
+}
+
+// UsedCores returns the used cores of this GPU device
+func (d *DeviceInfo) UsedCores() uint {
+	return d.usedCore
+}
+
+// UsedMemory returns the used memory of this GPU device
+func (d *DeviceInfo) UsedMemory() uint {
+	return d.usedMemory
+}
+
+// IsIdle tells if this GPU device is not used by anyone, only an idle device can be
+// allocated exclusively
+func (d *DeviceInfo) IsIdle() bool {
+	return d.usedCore == 0 && d.usedMemory == 0
+}
+
+// GetState returns whether this GPU device can be allocated
+func (d *DeviceInfo) GetState() DeviceState {
+	return d.state
+}
+
+// IsAllocatable tells if this GPU device can be allocated to new containers, the
+// containers running on it are still accounted even if it's not allocatable
+func (d *DeviceInfo) IsAllocatable() bool {
+	return d.state == DeviceAllocatable
+}
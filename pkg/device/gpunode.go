@@ -0,0 +1,66 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package device
+
+import (
+	"k8s.io/klog"
+
+	gpuv1alpha1 "tkestack.io/gpu-admission/pkg/apis/gpu/v1alpha1"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// WithGPUNode builds the devices of the node from the GPUNode published by
+// gpu-manager or a node agent, instead of inferring them from the node capacity
+func WithGPUNode(gpuNode *gpuv1alpha1.GPUNode) Option {
+	return func(o *options) {
+		o.gpuNode = gpuNode
+	}
+}
+
+// devicesOfGPUNode returns the devices described by GPUNode, whose capacity is scaled
+// by overcommit ratios. Nil is returned if there's no GPUNode or it's invalid, e.g.
+// the indexes of devices are not continuous.
+func devicesOfGPUNode(gpuNode *gpuv1alpha1.GPUNode, coreRatio,
+	memoryRatio float64) map[int]*DeviceInfo {
+	if gpuNode == nil || len(gpuNode.Spec.Devices) == 0 {
+		return nil
+	}
+	var (
+		devices   = gpuNode.Spec.Devices
+		devMap    = make(map[int]*DeviceInfo, len(devices))
+		totalCore = uint(float64(util.HundredCore) * coreRatio)
+	)
+	for _, d := range devices {
+		if d.Index < 0 || d.Index >= len(devices) || devMap[d.Index] != nil {
+			klog.Infof("invalid device index %d of GPUNode %s, ignore it", d.Index,
+				gpuNode.Name)
+			return nil
+		}
+		dev := newDeviceInfo(d.Index, totalCore, uint(float64(d.Memory)*memoryRatio))
+		dev.uuid = d.UUID
+		dev.model = d.Model
+		dev.numaNode = d.NUMANode
+		for _, link := range d.Links {
+			dev.links = append(dev.links, Link{Index: link.Index, Type: link.Type})
+		}
+		if d.Health == gpuv1alpha1.GPUDeviceUnhealthy {
+			dev.state = DeviceUnhealthy
+		}
+		devMap[d.Index] = dev
+	}
+	return devMap
+}
@@ -17,6 +17,7 @@
 package device
 
 import (
+	"fmt"
 	"sort"
 
 	"k8s.io/api/core/v1"
@@ -26,71 +27,207 @@ import (
 )
 
 type NodeInfo struct {
-	name        string
-	node        *v1.Node
-	devs        map[int]*DeviceInfo
-	deviceCount int
-	totalMemory uint
-	usedCore    uint
-	usedMemory  uint
+	name                  string
+	node                  *v1.Node
+	devs                  map[int]*DeviceInfo
+	deviceCount           int
+	totalCore             uint
+	totalMemory           uint
+	usedCore              uint
+	usedMemory            uint
+	coreOvercommitRatio   float64
+	memoryOvercommitRatio float64
+	// reservations hide devices from pods not in their allow-lists
+	reservations []*Reservation
+	// expiredReservations is the number of pods predicated to the node whose
+	// reservations have expired
+	expiredReservations int
 }
 
-func NewNodeInfo(node *v1.Node, pods []*v1.Pod) *NodeInfo {
+func NewNodeInfo(node *v1.Node, pods []*v1.Pod, opts ...Option) *NodeInfo {
 	klog.V(4).Infof("debug: NewNodeInfo() creates nodeInfo for %s", node.Name)
 
-	devMap := map[int]*DeviceInfo{}
-	nodeTotalMemory := uint(util.GetCapacityOfNode(node, util.VMemoryAnnotation))
-	deviceCount := util.GetGPUDeviceCountOfNode(node)
-	deviceTotalMemory := nodeTotalMemory / uint(deviceCount)
-	for i := 0; i < deviceCount; i++ {
-		devMap[i] = newDeviceInfo(i, deviceTotalMemory)
+	o := newOptions(opts)
+	coreRatio := o.coreRatio(node)
+	memoryRatio := o.memoryRatio(node)
+
+	// capacity of each device is scaled by overcommit ratios
+	devMap := devicesOfGPUNode(o.gpuNode, coreRatio, memoryRatio)
+	if devMap == nil {
+		devMap = map[int]*DeviceInfo{}
+		nodeTotalMemory := uint(util.GetCapacityOfNode(node, util.VMemoryAnnotation))
+		deviceCount := util.GetGPUDeviceCountOfNode(node)
+		deviceTotalCore := uint(float64(util.HundredCore) * coreRatio)
+		deviceTotalMemory := uint(float64(nodeTotalMemory/uint(deviceCount)) * memoryRatio)
+		for i := 0; i < deviceCount; i++ {
+			devMap[i] = newDeviceInfo(i, deviceTotalCore, deviceTotalMemory)
+		}
 	}
+	deviceCount := len(devMap)
 
 	ret := &NodeInfo{
-		name:        node.Name,
-		node:        node,
-		devs:        devMap,
-		deviceCount: deviceCount,
-		totalMemory: nodeTotalMemory,
+		name:                  node.Name,
+		node:                  node,
+		devs:                  devMap,
+		deviceCount:           deviceCount,
+		coreOvercommitRatio:   coreRatio,
+		memoryOvercommitRatio: memoryRatio,
+		reservations:          o.reservations.reservationsOfNode(node),
 	}
+	for _, dev := range devMap {
+		ret.totalCore += dev.totalCore
+		ret.totalMemory += dev.totalMemory
+	}
+
+	// Mark the devices which are unhealthy or drained by operators
+	ret.markDevices(util.UnhealthyGPUIndexAnnotation, DeviceUnhealthy)
+	ret.markDevices(util.DrainedGPUIndexAnnotation, DeviceDrained)
 
 	// According to the pods' annotations, construct the node allocation
 	// state
+	var (
+		wholeGPUContainers []containerOfPod
+		now                = o.now()
+	)
 	for _, pod := range pods {
+		if util.IsReservationExpired(pod, o.reservationTTL, now) {
+			klog.V(4).Infof("reservation of pod %s/%s on node %s expired", pod.Namespace,
+				pod.Name, node.Name)
+			ret.expiredReservations++
+			continue
+		}
 		for i, c := range pod.Spec.Containers {
-			predicateIndexes, err := util.GetPredicateIdxOfContainer(pod, i)
+			predicateIndexes, err := o.predicateIndexes(pod, i)
 			if err != nil {
+				// whole-GPU pods may be scheduled without our predication, e.g.
+				// by default scheduler, they still own some devices on this node
+				if pod.Spec.NodeName == node.Name && util.IsWholeGPUContainer(&c) {
+					wholeGPUContainers = append(wholeGPUContainers,
+						containerOfPod{pod: pod, container: &pod.Spec.Containers[i]})
+				}
 				continue
 			}
 			for _, index := range predicateIndexes {
 				var vcore, vmemory uint
-				if index >= deviceCount {
-					klog.Infof("invalid predicateIndex %d larger than device count", index)
+				if index < 0 || index >= deviceCount {
+					klog.Infof("invalid predicateIndex %d out of device count", index)
 					continue
 				}
-				vcore = util.GetGPUResourceOfContainer(&c, util.VCoreAnnotation)
+				vcore = util.GetGPUCoresOfContainer(&c)
 				if vcore < util.HundredCore {
 					vmemory = util.GetGPUResourceOfContainer(&c, util.VMemoryAnnotation)
 				} else {
-					vcore = util.HundredCore
-					vmemory = deviceTotalMemory
+					// exclusive containers hold the whole device, including the
+					// overcommitted part
+					vcore = ret.devs[index].totalCore
+					vmemory = ret.devs[index].totalMemory
 				}
 				err = ret.AddUsedResources(index, vcore, vmemory)
 				if err != nil {
-					klog.Infof("failed to update used resource for node %s dev %d due to %v",
-						node.Name, index, err)
+					// the container holds the device already, e.g. the overcommit ratio
+					// was lowered after it was placed, so the device is marked full
+					// instead of under-counting its usage
+					klog.Infof("device %d of node %s is overbooked by pod %s/%s: %v", index,
+						node.Name, pod.Namespace, pod.Name, err)
+					ret.fillDevice(index)
 				}
+				ret.devs[index].addAllocation(newAllocation(pod, &c, vcore, vmemory))
 			}
 
 		}
 	}
 
+	// We don't know which devices are held by unpredicated whole-GPU containers, the
+	// nvidia device plugin chooses them by itself. Their usage is accounted on idle
+	// devices from the highest index, and the other idle devices are not allocated,
+	// as any of them may be the one really held.
+	for _, c := range wholeGPUContainers {
+		num := int(util.GetGPUResourceOfContainer(c.container, util.NvidiaGPUResource))
+		for index := deviceCount - 1; index >= 0 && num > 0; index-- {
+			if !ret.devs[index].IsIdle() {
+				continue
+			}
+			dev := ret.devs[index]
+			if err := ret.AddUsedResources(index, dev.totalCore, dev.totalMemory); err == nil {
+				dev.addAllocation(newAllocation(c.pod, c.container, dev.totalCore, dev.totalMemory))
+				num--
+			}
+		}
+		if num > 0 {
+			klog.Infof("node %s has no enough idle devices for whole-GPU container %s of pod %s",
+				node.Name, c.container.Name, c.pod.Name)
+		}
+	}
+	if len(wholeGPUContainers) > 0 {
+		for _, dev := range ret.devs {
+			if dev.IsIdle() && dev.state == DeviceAllocatable {
+				dev.state = DeviceUnknown
+			}
+		}
+	}
+
 	return ret
 }
 
+// markDevices sets the state of devices listed by the node annotation
+func (n *NodeInfo) markDevices(annotation string, state DeviceState) {
+	value, ok := n.node.Annotations[annotation]
+	if !ok {
+		return
+	}
+	indexes, err := util.ParseDeviceIndexes(value)
+	if err != nil {
+		klog.Infof("invalid annotation %s=%s of node %s: %v", annotation, value, n.name, err)
+		return
+	}
+	for _, index := range indexes {
+		dev, ok := n.devs[index]
+		if !ok {
+			klog.Infof("device %d in annotation %s of node %s not found", index, annotation, n.name)
+			continue
+		}
+		// unhealthy takes precedence over drained
+		if dev.state == DeviceAllocatable {
+			dev.state = state
+		}
+	}
+}
+
+type containerOfPod struct {
+	pod       *v1.Pod
+	container *v1.Container
+}
+
+func newAllocation(pod *v1.Pod, c *v1.Container, vcore, vmemory uint) Allocation {
+	return Allocation{
+		Namespace: pod.Namespace,
+		Pod:       pod.Name,
+		Container: c.Name,
+		Cores:     vcore,
+		Memory:    vmemory,
+	}
+}
+
+// Clone returns a copy of this NodeInfo, the allocation of the copy doesn't change
+// the original one
+func (n *NodeInfo) Clone() *NodeInfo {
+	ret := *n
+	ret.devs = make(map[int]*DeviceInfo, len(n.devs))
+	for id, dev := range n.devs {
+		d := *dev
+		d.allocations = append([]Allocation(nil), dev.allocations...)
+		ret.devs[id] = &d
+	}
+	return &ret
+}
+
 // AddUsedResources records the used GPU core and memory
 func (n *NodeInfo) AddUsedResources(devID int, vcore uint, vmemory uint) error {
-	err := n.devs[devID].AddUsedResources(vcore, vmemory)
+	dev, ok := n.devs[devID]
+	if !ok {
+		return fmt.Errorf("device %d not found on node %s", devID, n.name)
+	}
+	err := dev.AddUsedResources(vcore, vmemory)
 	if err != nil {
 		klog.Infof("failed to update used resource for node %s dev %d due to %v", n.name, devID, err)
 		return err
@@ -100,6 +237,15 @@ func (n *NodeInfo) AddUsedResources(devID int, vcore uint, vmemory uint) error {
 	return nil
 }
 
+// fillDevice marks all cores and memory of the device used
+func (n *NodeInfo) fillDevice(devID int) {
+	dev := n.devs[devID]
+	n.usedCore += dev.totalCore - dev.usedCore
+	n.usedMemory += dev.totalMemory - dev.usedMemory
+	dev.usedCore = dev.totalCore
+	dev.usedMemory = dev.totalMemory
+}
+
 // GetDeviceCount returns the number of GPU devices
 func (n *NodeInfo) GetDeviceCount() int {
 	return n.deviceCount
@@ -120,14 +266,62 @@ func (n *NodeInfo) GetName() string {
 	return n.name
 }
 
-// GetAvailableCore returns the remaining cores of this node
+// GetCoreOvercommitRatio returns the ratio which GPU cores of each device are scaled by
+func (n *NodeInfo) GetCoreOvercommitRatio() float64 {
+	return n.coreOvercommitRatio
+}
+
+// GetMemoryOvercommitRatio returns the ratio which GPU memory of each device is scaled by
+func (n *NodeInfo) GetMemoryOvercommitRatio() float64 {
+	return n.memoryOvercommitRatio
+}
+
+// GetExpiredReservations returns the number of pods predicated to this node which
+// are not accounted because their reservations have expired
+func (n *NodeInfo) GetExpiredReservations() int {
+	return n.expiredReservations
+}
+
+// GetTotalCore returns the total cores of this node
+func (n *NodeInfo) GetTotalCore() int {
+	return int(n.totalCore)
+}
+
+// GetTotalMemory returns the total memory of this node
+func (n *NodeInfo) GetTotalMemory() int {
+	return int(n.totalMemory)
+}
+
+// GetUsedCore returns the used cores of this node
+func (n *NodeInfo) GetUsedCore() int {
+	return int(n.usedCore)
+}
+
+// GetUsedMemory returns the used memory of this node
+func (n *NodeInfo) GetUsedMemory() int {
+	return int(n.usedMemory)
+}
+
+// GetAvailableCore returns the remaining cores of allocatable devices of this node
 func (n *NodeInfo) GetAvailableCore() int {
-	return n.deviceCount*util.HundredCore - int(n.usedCore)
+	var available uint
+	for _, dev := range n.devs {
+		if dev.IsAllocatable() {
+			available += dev.AllocatableCores()
+		}
+	}
+	return int(available)
 }
 
-// GetAvailableMemory returns the remaining memory of this node
+// GetAvailableMemory returns the remaining memory of allocatable devices of this node
 func (n *NodeInfo) GetAvailableMemory() int {
-	return int(n.totalMemory - n.usedMemory)
+	var available uint
+	for _, dev := range n.devs {
+		if dev.IsAllocatable() {
+			available += dev.AllocatableMemory()
+		}
+	}
+	return int(available)
 }
 
 type nodeInfoPriority struct {
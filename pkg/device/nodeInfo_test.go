@@ -0,0 +1,388 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package device
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	gpuv1alpha1 "tkestack.io/gpu-admission/pkg/apis/gpu/v1alpha1"
+	"tkestack.io/gpu-admission/pkg/util"
+	utiltesting "tkestack.io/gpu-admission/pkg/util/testing"
+)
+
+const (
+	testNodeName    = "testnode"
+	testDeviceCount = 4
+	testTotalMemory = 16
+)
+
+func newTestNode() *corev1.Node {
+	return utiltesting.NewNode(testNodeName, testDeviceCount, testTotalMemory)
+}
+
+func newTestPod(name string, limits corev1.ResourceList, annotations map[string]string) *corev1.Pod {
+	pod := utiltesting.NewPod("test-ns", name, corev1.Container{
+		Name: "container-0",
+		Resources: corev1.ResourceRequirements{
+			Limits: limits,
+		},
+	})
+	pod.Annotations = annotations
+	pod.Spec.NodeName = testNodeName
+	return pod
+}
+
+func TestNewNodeInfoWholeGPU(t *testing.T) {
+	pods := []*corev1.Pod{
+		// shared pod predicated by us
+		newTestPod("pod-shared", corev1.ResourceList{
+			util.VCoreAnnotation:   resource.MustParse("50"),
+			util.VMemoryAnnotation: resource.MustParse("2"),
+		}, map[string]string{
+			util.PredicateGPUIndexPrefix + "0": "0",
+		}),
+		// whole-GPU pod predicated by us
+		newTestPod("pod-whole-predicated", corev1.ResourceList{
+			util.NvidiaGPUResource: resource.MustParse("1"),
+		}, map[string]string{
+			util.PredicateGPUIndexPrefix + "0": "1",
+		}),
+		// whole-GPU pod scheduled without predication
+		newTestPod("pod-whole-unknown", corev1.ResourceList{
+			util.NvidiaGPUResource: resource.MustParse("1"),
+		}, nil),
+	}
+
+	nodeInfo := NewNodeInfo(newTestNode(), pods)
+
+	expectUsedCores := map[int]uint{0: 50, 1: 100, 2: 0, 3: 100}
+	for id, dev := range nodeInfo.GetDeviceMap() {
+		if used := util.HundredCore - dev.AllocatableCores(); used != expectUsedCores[id] {
+			t.Errorf("device %d used cores %d, expect %d", id, used, expectUsedCores[id])
+		}
+	}
+	allocs := nodeInfo.GetDeviceMap()[3].GetAllocations()
+	if len(allocs) != 1 || allocs[0].Pod != "pod-whole-unknown" || allocs[0].Memory != 4 {
+		t.Errorf("wrong allocations of device 3: %+v", allocs)
+	}
+	// device 2 may be the one held by the unpredicated pod
+	if state := nodeInfo.GetDeviceMap()[2].GetState(); state != DeviceUnknown {
+		t.Errorf("device 2 is %s, expect %s", state, DeviceUnknown)
+	}
+	if nodeInfo.GetAvailableCore() != 50 {
+		t.Errorf("available cores %d, expect 50", nodeInfo.GetAvailableCore())
+	}
+}
+
+func TestNewNodeInfoWholeGPUUnknownDevices(t *testing.T) {
+	pods := []*corev1.Pod{
+		newTestPod("pod-shared", corev1.ResourceList{
+			util.VCoreAnnotation:   resource.MustParse("50"),
+			util.VMemoryAnnotation: resource.MustParse("2"),
+		}, map[string]string{
+			util.PredicateGPUIndexPrefix + "0": "0",
+		}),
+		// the device plugin may have given any idle device to the pod
+		newTestPod("pod-whole-unknown", corev1.ResourceList{
+			util.NvidiaGPUResource: resource.MustParse("1"),
+		}, nil),
+	}
+
+	nodeInfo := NewNodeInfo(newTestNode(), pods)
+
+	expectStates := map[int]DeviceState{
+		0: DeviceAllocatable,
+		1: DeviceUnknown,
+		2: DeviceUnknown,
+		3: DeviceAllocatable,
+	}
+	for id, dev := range nodeInfo.GetDeviceMap() {
+		if dev.GetState() != expectStates[id] {
+			t.Errorf("device %d is %s, expect %s", id, dev.GetState(), expectStates[id])
+		}
+	}
+	if nodeInfo.GetDeviceMap()[3].AllocatableCores() != 0 {
+		t.Errorf("device 3 should be accounted to the whole-GPU pod")
+	}
+	if nodeInfo.GetAvailableCore() != 50 {
+		t.Errorf("available cores %d, expect 50", nodeInfo.GetAvailableCore())
+	}
+}
+
+func TestNewNodeInfoOvercommit(t *testing.T) {
+	node := newTestNode()
+	node.Labels = map[string]string{
+		util.CoreOvercommitRatioLabel: "2",
+	}
+	pods := []*corev1.Pod{
+		newTestPod("pod-shared-0", corev1.ResourceList{
+			util.VCoreAnnotation:   resource.MustParse("80"),
+			util.VMemoryAnnotation: resource.MustParse("4"),
+		}, map[string]string{
+			util.PredicateGPUIndexPrefix + "0": "0",
+		}),
+		newTestPod("pod-shared-1", corev1.ResourceList{
+			util.VCoreAnnotation:   resource.MustParse("80"),
+			util.VMemoryAnnotation: resource.MustParse("2"),
+		}, map[string]string{
+			util.PredicateGPUIndexPrefix + "0": "0",
+		}),
+		newTestPod("pod-exclusive", corev1.ResourceList{
+			util.VCoreAnnotation:   resource.MustParse("100"),
+			util.VMemoryAnnotation: resource.MustParse("4"),
+		}, map[string]string{
+			util.PredicateGPUIndexPrefix + "0": "1",
+		}),
+	}
+
+	nodeInfo := NewNodeInfo(node, pods, WithOvercommitRatio(1, 1.5))
+
+	if nodeInfo.GetCoreOvercommitRatio() != 2 || nodeInfo.GetMemoryOvercommitRatio() != 1.5 {
+		t.Fatalf("wrong ratios %v, %v", nodeInfo.GetCoreOvercommitRatio(),
+			nodeInfo.GetMemoryOvercommitRatio())
+	}
+	dev0, dev1 := nodeInfo.GetDeviceMap()[0], nodeInfo.GetDeviceMap()[1]
+	if dev0.TotalCores() != 200 || dev0.TotalMemory() != 6 {
+		t.Fatalf("wrong capacity of device 0: %d cores, %d memory", dev0.TotalCores(),
+			dev0.TotalMemory())
+	}
+	if dev0.AllocatableCores() != 40 || dev0.AllocatableMemory() != 0 || dev0.IsIdle() {
+		t.Fatalf("wrong allocatable resources of device 0: %d cores, %d memory",
+			dev0.AllocatableCores(), dev0.AllocatableMemory())
+	}
+	if dev1.AllocatableCores() != 0 || dev1.AllocatableMemory() != 0 {
+		t.Fatalf("exclusive device 1 should be fully used: %d cores, %d memory",
+			dev1.AllocatableCores(), dev1.AllocatableMemory())
+	}
+	if nodeInfo.GetTotalCore() != 800 || nodeInfo.GetAvailableCore() != 440 {
+		t.Fatalf("wrong cores of node: %d total, %d available", nodeInfo.GetTotalCore(),
+			nodeInfo.GetAvailableCore())
+	}
+}
+
+func TestNewNodeInfoOvercommitLowered(t *testing.T) {
+	// the pods were placed on device 0 while the core overcommit ratio was 2
+	pods := []*corev1.Pod{
+		newTestPod("pod-shared-0", corev1.ResourceList{
+			util.VCoreAnnotation:   resource.MustParse("80"),
+			util.VMemoryAnnotation: resource.MustParse("1"),
+		}, map[string]string{
+			util.PredicateGPUIndexPrefix + "0": "0",
+		}),
+		newTestPod("pod-shared-1", corev1.ResourceList{
+			util.VCoreAnnotation:   resource.MustParse("80"),
+			util.VMemoryAnnotation: resource.MustParse("1"),
+		}, map[string]string{
+			util.PredicateGPUIndexPrefix + "0": "0",
+		}),
+	}
+
+	nodeInfo := NewNodeInfo(newTestNode(), pods)
+
+	dev0 := nodeInfo.GetDeviceMap()[0]
+	if dev0.AllocatableCores() != 0 || dev0.AllocatableMemory() != 0 || dev0.IsIdle() {
+		t.Fatalf("overbooked device 0 should be full: %d cores, %d memory",
+			dev0.AllocatableCores(), dev0.AllocatableMemory())
+	}
+	if len(dev0.GetAllocations()) != 2 {
+		t.Fatalf("both pods should be accounted: %+v", dev0.GetAllocations())
+	}
+	if nodeInfo.GetUsedCore() != 100 || nodeInfo.GetUsedMemory() != 4 ||
+		nodeInfo.GetAvailableCore() != 300 {
+		t.Fatalf("wrong usage of node: %d used cores, %d used memory, %d available cores",
+			nodeInfo.GetUsedCore(), nodeInfo.GetUsedMemory(), nodeInfo.GetAvailableCore())
+	}
+}
+
+func TestNewNodeInfoUnallocatableDevices(t *testing.T) {
+	node := newTestNode()
+	node.Annotations = map[string]string{
+		util.UnhealthyGPUIndexAnnotation: "1",
+		util.DrainedGPUIndexAnnotation:   "1,2",
+	}
+	pods := []*corev1.Pod{
+		newTestPod("pod-shared", corev1.ResourceList{
+			util.VCoreAnnotation:   resource.MustParse("50"),
+			util.VMemoryAnnotation: resource.MustParse("2"),
+		}, map[string]string{
+			util.PredicateGPUIndexPrefix + "0": "1",
+		}),
+	}
+
+	nodeInfo := NewNodeInfo(node, pods)
+
+	expectStates := map[int]DeviceState{
+		0: DeviceAllocatable,
+		1: DeviceUnhealthy,
+		2: DeviceDrained,
+		3: DeviceAllocatable,
+	}
+	for id, dev := range nodeInfo.GetDeviceMap() {
+		if dev.GetState() != expectStates[id] {
+			t.Errorf("device %d state %s, expect %s", id, dev.GetState(), expectStates[id])
+		}
+	}
+	// tenants of unhealthy device are still accounted
+	if nodeInfo.GetDeviceMap()[1].UsedCores() != 50 {
+		t.Errorf("used cores of device 1 %d, expect 50", nodeInfo.GetDeviceMap()[1].UsedCores())
+	}
+	if nodeInfo.GetAvailableCore() != 200 {
+		t.Errorf("available cores %d, expect 200", nodeInfo.GetAvailableCore())
+	}
+}
+
+func TestNewNodeInfoExpiredReservations(t *testing.T) {
+	now := time.Unix(1000, 0)
+	predicated := func(name string, predicateTime time.Time) *corev1.Pod {
+		pod := newTestPod(name, corev1.ResourceList{
+			util.VCoreAnnotation:   resource.MustParse("30"),
+			util.VMemoryAnnotation: resource.MustParse("1"),
+		}, map[string]string{
+			util.PredicateGPUIndexPrefix + "0": "0",
+			util.PredicateNode:                 testNodeName,
+			util.PredicateTimeAnnotation:       fmt.Sprintf("%d", predicateTime.UnixNano()),
+		})
+		pod.Spec.NodeName = ""
+		return pod
+	}
+	bound := predicated("pod-bound", now.Add(-time.Hour))
+	bound.Spec.NodeName = testNodeName
+	pods := []*corev1.Pod{
+		predicated("pod-fresh", now.Add(-time.Second)),
+		predicated("pod-expired", now.Add(-time.Minute)),
+		bound,
+	}
+	withNow := func(o *options) {
+		o.now = func() time.Time { return now }
+	}
+
+	nodeInfo := NewNodeInfo(newTestNode(), pods, WithReservationTTL(30*time.Second), withNow)
+
+	if nodeInfo.GetExpiredReservations() != 1 {
+		t.Errorf("expired reservations %d, expect 1", nodeInfo.GetExpiredReservations())
+	}
+	if used := nodeInfo.GetDeviceMap()[0].UsedCores(); used != 60 {
+		t.Errorf("used cores of device 0 %d, expect 60", used)
+	}
+
+	// reservations never expire without ttl
+	nodeInfo = NewNodeInfo(newTestNode(), pods, withNow)
+	if nodeInfo.GetExpiredReservations() != 0 || nodeInfo.GetDeviceMap()[0].UsedCores() != 90 {
+		t.Errorf("reservations should not expire without ttl: %d expired, %d used cores",
+			nodeInfo.GetExpiredReservations(), nodeInfo.GetDeviceMap()[0].UsedCores())
+	}
+}
+
+func TestNewNodeInfoGPUNode(t *testing.T) {
+	gpuNode := &gpuv1alpha1.GPUNode{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: testNodeName,
+		},
+		Spec: gpuv1alpha1.GPUNodeSpec{
+			Devices: []gpuv1alpha1.GPUDevice{
+				{Index: 1, UUID: "GPU-1", Model: "T4", Memory: 64, NUMANode: 1},
+				{Index: 0, UUID: "GPU-0", Model: "V100", Memory: 128,
+					Links: []gpuv1alpha1.GPULink{{Index: 2, Type: "NVLink"}}},
+				{Index: 2, Memory: 128, Health: gpuv1alpha1.GPUDeviceUnhealthy},
+			},
+		},
+	}
+	pods := []*corev1.Pod{
+		newTestPod("pod-exclusive", corev1.ResourceList{
+			util.VCoreAnnotation:   resource.MustParse("100"),
+			util.VMemoryAnnotation: resource.MustParse("4"),
+		}, map[string]string{
+			util.PredicateGPUIndexPrefix + "0": "1",
+		}),
+	}
+
+	nodeInfo := NewNodeInfo(newTestNode(), pods, WithGPUNode(gpuNode),
+		WithOvercommitRatio(1, 1.5))
+
+	if nodeInfo.GetDeviceCount() != 3 || nodeInfo.GetTotalMemory() != 480 {
+		t.Fatalf("wrong devices of node: %d devices, %d memory", nodeInfo.GetDeviceCount(),
+			nodeInfo.GetTotalMemory())
+	}
+	dev0, dev1, dev2 := nodeInfo.GetDeviceMap()[0], nodeInfo.GetDeviceMap()[1],
+		nodeInfo.GetDeviceMap()[2]
+	if dev0.GetUUID() != "GPU-0" || dev0.GetModel() != "V100" || dev0.TotalMemory() != 192 ||
+		len(dev0.GetLinks()) != 1 {
+		t.Errorf("wrong device 0: %+v", dev0)
+	}
+	if dev1.GetNUMANode() != 1 || dev1.UsedMemory() != 96 {
+		t.Errorf("wrong device 1: %+v", dev1)
+	}
+	if dev2.GetState() != DeviceUnhealthy {
+		t.Errorf("device 2 state %s, expect %s", dev2.GetState(), DeviceUnhealthy)
+	}
+	if nodeInfo.GetAvailableMemory() != 192 {
+		t.Errorf("available memory %d, expect 192", nodeInfo.GetAvailableMemory())
+	}
+
+	// invalid GPUNode is ignored
+	gpuNode.Spec.Devices[0].Index = 3
+	nodeInfo = NewNodeInfo(newTestNode(), nil, WithGPUNode(gpuNode))
+	if nodeInfo.GetDeviceCount() != testDeviceCount {
+		t.Errorf("device count %d, expect %d", nodeInfo.GetDeviceCount(), testDeviceCount)
+	}
+}
+
+func TestNewNodeInfoInvalidOvercommitRatio(t *testing.T) {
+	for _, label := range []string{"NaN", "Inf", "-Inf", "1e308", "11", "0.5", "x"} {
+		node := newTestNode()
+		node.Labels = map[string]string{
+			util.CoreOvercommitRatioLabel:   label,
+			util.MemoryOvercommitRatioLabel: label,
+		}
+		nodeInfo := NewNodeInfo(node, nil, WithOvercommitRatio(2, 1.5))
+		if nodeInfo.GetCoreOvercommitRatio() != 2 || nodeInfo.GetMemoryOvercommitRatio() != 1.5 {
+			t.Errorf("label %s: got ratios %v, %v, expect the default ones", label,
+				nodeInfo.GetCoreOvercommitRatio(), nodeInfo.GetMemoryOvercommitRatio())
+		}
+		if nodeInfo.GetTotalCore() != 800 {
+			t.Errorf("label %s: got %d total cores, expect 800", label, nodeInfo.GetTotalCore())
+		}
+	}
+
+	for _, ratio := range []float64{math.NaN(), math.Inf(1), 1e308, 0} {
+		nodeInfo := NewNodeInfo(newTestNode(), nil, WithOvercommitRatio(ratio, ratio))
+		if nodeInfo.GetCoreOvercommitRatio() != 1 || nodeInfo.GetMemoryOvercommitRatio() != 1 {
+			t.Errorf("default ratio %v: got ratios %v, %v, expect 1", ratio,
+				nodeInfo.GetCoreOvercommitRatio(), nodeInfo.GetMemoryOvercommitRatio())
+		}
+	}
+}
+
+func TestValidateOvercommitRatio(t *testing.T) {
+	for _, ratio := range []float64{1, 1.5, MaxOvercommitRatio} {
+		if err := ValidateOvercommitRatio(ratio); err != nil {
+			t.Errorf("ratio %v should be valid, got %v", ratio, err)
+		}
+	}
+	for _, ratio := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), 1e308, 0.5, 0} {
+		if err := ValidateOvercommitRatio(ratio); err == nil {
+			t.Errorf("ratio %v should be invalid", ratio)
+		}
+	}
+}
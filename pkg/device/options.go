@@ -0,0 +1,151 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package device
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+
+	gpuv1alpha1 "tkestack.io/gpu-admission/pkg/apis/gpu/v1alpha1"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// Option configures how NodeInfo is built
+type Option func(*options)
+
+type options struct {
+	coreOvercommitRatio   float64
+	memoryOvercommitRatio float64
+	reservations          *ReservationConfig
+	// reservationTTL is how long predicated but unbound pods hold their devices
+	reservationTTL time.Duration
+	now            func() time.Time
+	// allocations are the devices allocated to containers recorded by GPUAllocation
+	// objects, if it's nil the pod annotations are used
+	allocations map[containerKey]*gpuv1alpha1.GPUAllocation
+	// gpuNode describes the devices of the node, if it's nil the devices are inferred
+	// from the capacity of node
+	gpuNode *gpuv1alpha1.GPUNode
+}
+
+type containerKey struct {
+	podUID types.UID
+	index  int
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		coreOvercommitRatio:   1,
+		memoryOvercommitRatio: 1,
+		now:                   time.Now,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithOvercommitRatio sets the default ratios which GPU cores and memory of each
+// device are scaled by, they are used if the node is not labeled with its own ratios
+func WithOvercommitRatio(core, memory float64) Option {
+	return func(o *options) {
+		o.coreOvercommitRatio = core
+		o.memoryOvercommitRatio = memory
+	}
+}
+
+// WithReservationTTL sets how long pods predicated but not bound hold their devices,
+// expired reservations are not accounted. A zero ttl means they never expire.
+func WithReservationTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.reservationTTL = ttl
+	}
+}
+
+// WithGPUAllocations rebuilds the allocation state of the node from GPUAllocation
+// objects, the pod annotations are used only for containers without the object
+func WithGPUAllocations(allocations []*gpuv1alpha1.GPUAllocation) Option {
+	return func(o *options) {
+		o.allocations = make(map[containerKey]*gpuv1alpha1.GPUAllocation, len(allocations))
+		for _, alloc := range allocations {
+			key := containerKey{podUID: alloc.Spec.PodUID, index: alloc.Spec.ContainerIndex}
+			o.allocations[key] = alloc
+		}
+	}
+}
+
+// predicateIndexes returns the indexes of devices allocated to the container of pod
+func (o *options) predicateIndexes(pod *v1.Pod, containerIndex int) ([]int, error) {
+	if alloc, ok := o.allocations[containerKey{podUID: pod.UID, index: containerIndex}]; ok {
+		return alloc.Spec.DeviceIndexes, nil
+	}
+	return util.GetPredicateIdxOfContainer(pod, containerIndex)
+}
+
+// MaxOvercommitRatio is the largest ratio GPU cores or memory of a device can be
+// scaled by, larger ones would overflow the capacity of devices
+const MaxOvercommitRatio = 10
+
+// ValidateOvercommitRatio returns an error if the ratio is not a finite number
+// between 1 and MaxOvercommitRatio
+func ValidateOvercommitRatio(ratio float64) error {
+	if math.IsNaN(ratio) || math.IsInf(ratio, 0) {
+		return fmt.Errorf("overcommit ratio %v is not a finite number", ratio)
+	}
+	if ratio < 1 || ratio > MaxOvercommitRatio {
+		return fmt.Errorf("overcommit ratio %v out of range [1, %d]", ratio, MaxOvercommitRatio)
+	}
+	return nil
+}
+
+// overcommitRatio returns the ratio of node label, or the default one if the node
+// doesn't have the label or its value is invalid
+func overcommitRatio(node *v1.Node, label string, defaultRatio float64) float64 {
+	ratio := defaultRatio
+	if v, ok := node.Labels[label]; ok {
+		r, err := strconv.ParseFloat(v, 64)
+		if err == nil {
+			err = ValidateOvercommitRatio(r)
+		}
+		if err != nil {
+			klog.Infof("invalid label %s=%s of node %s: %v", label, v, node.Name, err)
+		} else {
+			ratio = r
+		}
+	}
+	if err := ValidateOvercommitRatio(ratio); err != nil {
+		klog.Infof("invalid default ratio of node %s: %v, ignore it", node.Name, err)
+		ratio = 1
+	}
+	return ratio
+}
+
+// coreRatio returns the ratio which GPU cores of the node are scaled by
+func (o *options) coreRatio(node *v1.Node) float64 {
+	return overcommitRatio(node, util.CoreOvercommitRatioLabel, o.coreOvercommitRatio)
+}
+
+// memoryRatio returns the ratio which GPU memory of the node is scaled by
+func (o *options) memoryRatio(node *v1.Node) float64 {
+	return overcommitRatio(node, util.MemoryOvercommitRatioLabel, o.memoryOvercommitRatio)
+}
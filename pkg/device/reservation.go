@@ -0,0 +1,162 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// ReservationConfig declares GPU devices kept for system workloads
+type ReservationConfig struct {
+	// Reservations are applied to the nodes matching their node selectors
+	Reservations []Reservation `json:"reservations"`
+	// PriorityClasses and Namespaces are allowed to use the devices reserved by
+	// node label
+	PriorityClasses []string `json:"priorityClasses,omitempty"`
+	Namespaces      []string `json:"namespaces,omitempty"`
+}
+
+// Reservation hides a number of devices, or specific indexes, from pods which are
+// not in the allow-list
+type Reservation struct {
+	// NodeSelector is a label selector of nodes, all nodes are selected if it's empty
+	NodeSelector string `json:"nodeSelector,omitempty"`
+	// Count is the number of reserved devices, idle devices with higher indexes are
+	// reserved first
+	Count int `json:"count,omitempty"`
+	// Indexes are the reserved device indexes
+	Indexes []int `json:"indexes,omitempty"`
+	// PriorityClasses and Namespaces are allowed to use the reserved devices
+	PriorityClasses []string `json:"priorityClasses,omitempty"`
+	Namespaces      []string `json:"namespaces,omitempty"`
+
+	selector labels.Selector
+}
+
+// LoadReservationConfig reads the reservation config from a JSON file
+func LoadReservationConfig(path string) (*ReservationConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &ReservationConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse reservation config %s: %v", path, err)
+	}
+	for i := range config.Reservations {
+		r := &config.Reservations[i]
+		if r.selector, err = labels.Parse(r.NodeSelector); err != nil {
+			return nil, fmt.Errorf("invalid node selector %q of reservation %d: %v",
+				r.NodeSelector, i, err)
+		}
+	}
+	return config, nil
+}
+
+// WithReservations sets the reservations applied to nodes
+func WithReservations(config *ReservationConfig) Option {
+	return func(o *options) {
+		o.reservations = config
+	}
+}
+
+// reservationsOfNode returns the reservations of config selecting the node, and the
+// one declared by node label
+func (c *ReservationConfig) reservationsOfNode(node *v1.Node) []*Reservation {
+	var ret []*Reservation
+	if c != nil {
+		for i := range c.Reservations {
+			r := &c.Reservations[i]
+			if r.selector == nil || r.selector.Matches(labels.Set(node.Labels)) {
+				ret = append(ret, r)
+			}
+		}
+	}
+	if v, ok := node.Labels[util.ReservedGPUCountLabel]; ok {
+		count, err := strconv.Atoi(v)
+		if err != nil || count < 0 {
+			klog.Infof("invalid label %s=%s of node %s", util.ReservedGPUCountLabel, v, node.Name)
+			return ret
+		}
+		r := &Reservation{Count: count}
+		if c != nil {
+			r.PriorityClasses = c.PriorityClasses
+			r.Namespaces = c.Namespaces
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+// allows tells if the pod can use the reserved devices
+func (r *Reservation) allows(pod *v1.Pod) bool {
+	for _, priorityClass := range r.PriorityClasses {
+		if pod.Spec.PriorityClassName == priorityClass {
+			return true
+		}
+	}
+	for _, namespace := range r.Namespaces {
+		if pod.Namespace == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// ReservedDevicesFor returns the indexes of devices which are hidden from the pod
+func (n *NodeInfo) ReservedDevicesFor(pod *v1.Pod) map[int]bool {
+	hidden := make(map[int]bool)
+	for _, r := range n.reservations {
+		if r.allows(pod) {
+			continue
+		}
+		for _, index := range r.Indexes {
+			hidden[index] = true
+		}
+		if r.Count <= 0 {
+			continue
+		}
+
+		// prefer idle devices with higher indexes, so the reserved devices keep idle
+		var candidates []*DeviceInfo
+		for _, dev := range n.devs {
+			if dev.IsAllocatable() && !hidden[dev.GetID()] {
+				candidates = append(candidates, dev)
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].IsIdle() != candidates[j].IsIdle() {
+				return candidates[i].IsIdle()
+			}
+			return candidates[i].GetID() > candidates[j].GetID()
+		})
+		for i := 0; i < r.Count && i < len(candidates); i++ {
+			hidden[candidates[i].GetID()] = true
+		}
+	}
+	return hidden
+}
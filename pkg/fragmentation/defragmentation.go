@@ -0,0 +1,354 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package fragmentation
+
+import (
+	"sort"
+
+	"tkestack.io/gpu-admission/pkg/device"
+)
+
+// Plan is a set of pod moves which frees whole GPU devices
+type Plan struct {
+	// Requested is the number of devices requested to be freed
+	Requested int `json:"requested"`
+	// Freed is the number of devices freed by the moves, it may be less than
+	// Requested if there is no enough room to move pods
+	Freed   int         `json:"freed"`
+	Devices []DeviceRef `json:"devices"`
+	Moves   []Move      `json:"moves"`
+}
+
+// DeviceRef refers to a GPU device of a node
+type DeviceRef struct {
+	Node  string `json:"node"`
+	Index int    `json:"index"`
+}
+
+// Move describes a pod which should be moved from a node to another one
+type Move struct {
+	Namespace  string          `json:"namespace"`
+	Pod        string          `json:"pod"`
+	FromNode   string          `json:"fromNode"`
+	ToNode     string          `json:"toNode"`
+	Containers []ContainerMove `json:"containers"`
+}
+
+// ContainerMove describes a slice of GPU device held by a container which should be
+// moved to another device
+type ContainerMove struct {
+	Container  string `json:"container"`
+	FromDevice int    `json:"fromDevice"`
+	ToDevice   int    `json:"toDevice"`
+	Cores      uint   `json:"cores"`
+	Memory     uint   `json:"memory"`
+}
+
+// Recommend proposes pod moves which would free n more whole GPU devices.
+//
+// Devices held by fewer pods are evacuated first, and every moved pod is packed
+// onto other devices in use with the same best-fit order as share mode, so no
+// idle device is consumed. Devices held by exclusive containers are never
+// evacuated. It's a greedy approach, the plan is small but not guaranteed to be
+// the minimal one.
+func Recommend(nodeInfos []*device.NodeInfo, n int) *Plan {
+	// #lizard forgives
+	st := newClusterState(nodeInfos)
+	plan := &Plan{
+		Requested: n,
+		Devices:   make([]DeviceRef, 0),
+		Moves:     make([]Move, 0),
+	}
+	frozen := make(map[DeviceRef]bool)
+
+	for _, candidate := range st.candidates() {
+		if plan.Freed >= n {
+			break
+		}
+		dev := st.device(candidate)
+		if dev.idle() {
+			continue
+		}
+		trial := st.clone()
+		moves, ok := trial.evacuate(candidate, frozen)
+		if !ok {
+			continue
+		}
+		st = trial
+		frozen[candidate] = true
+		plan.Moves = append(plan.Moves, moves...)
+		plan.Devices = st.freedDevices()
+		plan.Freed = len(plan.Devices)
+	}
+
+	return plan
+}
+
+type podKey struct {
+	namespace string
+	name      string
+}
+
+type devState struct {
+	ref         DeviceRef
+	totalCores  uint
+	totalMemory uint
+	usedCores   uint
+	usedMemory  uint
+	allocs      []device.Allocation
+	// idle at the beginning of the analysis
+	initialIdle bool
+	// unmovable is true if the device is held exclusively or by unknown tenants,
+	// or it's unhealthy or drained
+	unmovable bool
+}
+
+func (d *devState) idle() bool {
+	return d.usedCores == 0 && d.usedMemory == 0
+}
+
+func (d *devState) fits(cores, memory uint) bool {
+	return d.totalCores-d.usedCores >= cores && d.totalMemory-d.usedMemory >= memory
+}
+
+type clusterState struct {
+	nodes []string
+	devs  map[string][]*devState
+}
+
+func newClusterState(nodeInfos []*device.NodeInfo) *clusterState {
+	st := &clusterState{
+		devs: make(map[string][]*devState),
+	}
+	for _, nodeInfo := range nodeInfos {
+		name := nodeInfo.GetName()
+		st.nodes = append(st.nodes, name)
+		for _, dev := range nodeInfo.GetDeviceMap() {
+			d := &devState{
+				ref:         DeviceRef{Node: name, Index: dev.GetID()},
+				totalCores:  dev.TotalCores(),
+				totalMemory: dev.TotalMemory(),
+				usedCores:   dev.UsedCores(),
+				usedMemory:  dev.UsedMemory(),
+				allocs:      append([]device.Allocation(nil), dev.GetAllocations()...),
+			}
+			d.initialIdle = d.idle()
+			var cores, memory uint
+			for _, alloc := range d.allocs {
+				cores += alloc.Cores
+				memory += alloc.Memory
+				if alloc.Cores >= d.totalCores {
+					d.unmovable = true
+				}
+			}
+			if cores != d.usedCores || memory != d.usedMemory || !dev.IsAllocatable() {
+				d.unmovable = true
+			}
+			st.devs[name] = append(st.devs[name], d)
+		}
+		sort.Slice(st.devs[name], func(i, j int) bool {
+			return st.devs[name][i].ref.Index < st.devs[name][j].ref.Index
+		})
+	}
+	sort.Strings(st.nodes)
+	return st
+}
+
+func (st *clusterState) clone() *clusterState {
+	ret := &clusterState{
+		nodes: st.nodes,
+		devs:  make(map[string][]*devState, len(st.devs)),
+	}
+	for name, devs := range st.devs {
+		for _, dev := range devs {
+			d := *dev
+			d.allocs = append([]device.Allocation(nil), dev.allocs...)
+			ret.devs[name] = append(ret.devs[name], &d)
+		}
+	}
+	return ret
+}
+
+func (st *clusterState) device(ref DeviceRef) *devState {
+	for _, dev := range st.devs[ref.Node] {
+		if dev.ref.Index == ref.Index {
+			return dev
+		}
+	}
+	return nil
+}
+
+// candidates returns the devices in use which may be evacuated, devices held by
+// fewer pods and less memory come first
+func (st *clusterState) candidates() []DeviceRef {
+	type candidate struct {
+		dev  *devState
+		pods int
+	}
+	var cands []candidate
+	for _, name := range st.nodes {
+		for _, dev := range st.devs[name] {
+			if dev.idle() || dev.unmovable {
+				continue
+			}
+			pods := make(map[podKey]bool)
+			for _, alloc := range dev.allocs {
+				pods[podKey{alloc.Namespace, alloc.Pod}] = true
+			}
+			cands = append(cands, candidate{dev: dev, pods: len(pods)})
+		}
+	}
+	sort.SliceStable(cands, func(i, j int) bool {
+		if cands[i].pods != cands[j].pods {
+			return cands[i].pods < cands[j].pods
+		}
+		return cands[i].dev.usedMemory < cands[j].dev.usedMemory
+	})
+
+	ret := make([]DeviceRef, 0, len(cands))
+	for _, c := range cands {
+		ret = append(ret, c.dev.ref)
+	}
+	return ret
+}
+
+// freedDevices returns the devices which are idle now but were in use
+func (st *clusterState) freedDevices() []DeviceRef {
+	ret := make([]DeviceRef, 0)
+	for _, name := range st.nodes {
+		for _, dev := range st.devs[name] {
+			if dev.idle() && !dev.initialIdle {
+				ret = append(ret, dev.ref)
+			}
+		}
+	}
+	return ret
+}
+
+// evacuate moves all pods holding the target device to other devices in use
+func (st *clusterState) evacuate(target DeviceRef, frozen map[DeviceRef]bool) ([]Move, bool) {
+	// #lizard forgives
+	var (
+		moves []Move
+		pods  []podKey
+		seen  = make(map[podKey]bool)
+	)
+	for _, alloc := range st.device(target).allocs {
+		key := podKey{alloc.Namespace, alloc.Pod}
+		if !seen[key] {
+			seen[key] = true
+			pods = append(pods, key)
+		}
+	}
+
+	for _, pod := range pods {
+		// release all slices of the pod on this node, since the pod is moved as a whole
+		type slice struct {
+			alloc device.Allocation
+			from  int
+		}
+		var slices []slice
+		for _, dev := range st.devs[target.Node] {
+			kept := dev.allocs[:0]
+			for _, alloc := range dev.allocs {
+				if alloc.Namespace != pod.namespace || alloc.Pod != pod.name {
+					kept = append(kept, alloc)
+					continue
+				}
+				if dev.unmovable {
+					return nil, false
+				}
+				slices = append(slices, slice{alloc: alloc, from: dev.ref.Index})
+				dev.usedCores -= alloc.Cores
+				dev.usedMemory -= alloc.Memory
+			}
+			dev.allocs = kept
+		}
+
+		placed := false
+		for _, name := range st.nodes {
+			var (
+				picked     []*devState
+				containers []ContainerMove
+				trial      = make(map[*devState]devState)
+			)
+			for _, s := range slices {
+				dev := st.bestFit(name, s.alloc.Cores, s.alloc.Memory, target, frozen)
+				if dev == nil {
+					break
+				}
+				if _, ok := trial[dev]; !ok {
+					trial[dev] = *dev
+				}
+				dev.usedCores += s.alloc.Cores
+				dev.usedMemory += s.alloc.Memory
+				picked = append(picked, dev)
+				containers = append(containers, ContainerMove{
+					Container:  s.alloc.Container,
+					FromDevice: s.from,
+					ToDevice:   dev.ref.Index,
+					Cores:      s.alloc.Cores,
+					Memory:     s.alloc.Memory,
+				})
+			}
+			if len(picked) < len(slices) {
+				// roll back the partial placement on this node
+				for dev, orig := range trial {
+					dev.usedCores = orig.usedCores
+					dev.usedMemory = orig.usedMemory
+				}
+				continue
+			}
+			for i, dev := range picked {
+				dev.allocs = append(dev.allocs, slices[i].alloc)
+			}
+			moves = append(moves, Move{
+				Namespace:  pod.namespace,
+				Pod:        pod.name,
+				FromNode:   target.Node,
+				ToNode:     name,
+				Containers: containers,
+			})
+			placed = true
+			break
+		}
+		if !placed {
+			return nil, false
+		}
+	}
+	return moves, true
+}
+
+// bestFit returns the device in use of given node with the least allocatable
+// resources which fulfils the request
+func (st *clusterState) bestFit(node string, cores, memory uint, target DeviceRef,
+	frozen map[DeviceRef]bool) *devState {
+	var ret *devState
+	for _, dev := range st.devs[node] {
+		if dev.ref == target || frozen[dev.ref] || dev.idle() || dev.unmovable ||
+			!dev.fits(cores, memory) {
+			continue
+		}
+		if ret == nil ||
+			dev.totalCores-dev.usedCores < ret.totalCores-ret.usedCores ||
+			(dev.totalCores-dev.usedCores == ret.totalCores-ret.usedCores &&
+				dev.totalMemory-dev.usedMemory < ret.totalMemory-ret.usedMemory) {
+			ret = dev
+		}
+	}
+	return ret
+}
@@ -0,0 +1,108 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package fragmentation
+
+import (
+	"sort"
+
+	"tkestack.io/gpu-admission/pkg/device"
+)
+
+// Report describes the fragmentation of GPU devices of a set of nodes
+type Report struct {
+	TotalDevices   int `json:"totalDevices"`
+	IdleDevices    int `json:"idleDevices"`
+	PartialDevices int `json:"partialDevices"`
+	FullDevices    int `json:"fullDevices"`
+	// UnallocatableDevices are unhealthy or drained, they are not counted as
+	// idle, partial or full devices
+	UnallocatableDevices int `json:"unallocatableDevices"`
+	// ObtainableDevices is the number of whole idle devices obtainable after
+	// moving shared pods, including the devices which are idle already
+	ObtainableDevices int  `json:"obtainableDevices"`
+	FreeCores         uint `json:"freeCores"`
+	FreeMemory        uint `json:"freeMemory"`
+	// StrandedCores and StrandedMemory are the free resources of devices
+	// in use, they can't be used by exclusive requests
+	StrandedCores  uint `json:"strandedCores"`
+	StrandedMemory uint `json:"strandedMemory"`
+	// MemoryFragmentation is the ratio of stranded memory to free memory
+	MemoryFragmentation float64      `json:"memoryFragmentation"`
+	Nodes               []NodeReport `json:"nodes"`
+}
+
+// NodeReport describes the fragmentation of GPU devices of a node
+type NodeReport struct {
+	Name           string `json:"name"`
+	TotalDevices   int    `json:"totalDevices"`
+	IdleDevices    int    `json:"idleDevices"`
+	PartialDevices int    `json:"partialDevices"`
+	FullDevices    int    `json:"fullDevices"`
+	// UnallocatableDevices are unhealthy or drained
+	UnallocatableDevices int  `json:"unallocatableDevices"`
+	StrandedCores        uint `json:"strandedCores"`
+	StrandedMemory       uint `json:"strandedMemory"`
+}
+
+// Analyze computes the fragmentation metrics of given nodes
+func Analyze(nodeInfos []*device.NodeInfo) *Report {
+	report := &Report{
+		Nodes: make([]NodeReport, 0, len(nodeInfos)),
+	}
+	for _, nodeInfo := range nodeInfos {
+		nodeReport := NodeReport{
+			Name: nodeInfo.GetName(),
+		}
+		for _, dev := range nodeInfo.GetDeviceMap() {
+			nodeReport.TotalDevices++
+			if !dev.IsAllocatable() {
+				nodeReport.UnallocatableDevices++
+				continue
+			}
+			report.FreeCores += dev.AllocatableCores()
+			report.FreeMemory += dev.AllocatableMemory()
+			switch {
+			case dev.IsIdle():
+				nodeReport.IdleDevices++
+				continue
+			case dev.AllocatableCores() == 0 || dev.AllocatableMemory() == 0:
+				nodeReport.FullDevices++
+			default:
+				nodeReport.PartialDevices++
+			}
+			nodeReport.StrandedCores += dev.AllocatableCores()
+			nodeReport.StrandedMemory += dev.AllocatableMemory()
+		}
+		report.TotalDevices += nodeReport.TotalDevices
+		report.IdleDevices += nodeReport.IdleDevices
+		report.PartialDevices += nodeReport.PartialDevices
+		report.FullDevices += nodeReport.FullDevices
+		report.UnallocatableDevices += nodeReport.UnallocatableDevices
+		report.StrandedCores += nodeReport.StrandedCores
+		report.StrandedMemory += nodeReport.StrandedMemory
+		report.Nodes = append(report.Nodes, nodeReport)
+	}
+	sort.Slice(report.Nodes, func(i, j int) bool {
+		return report.Nodes[i].Name < report.Nodes[j].Name
+	})
+	if report.FreeMemory > 0 {
+		report.MemoryFragmentation = float64(report.StrandedMemory) / float64(report.FreeMemory)
+	}
+	report.ObtainableDevices = report.IdleDevices +
+		Recommend(nodeInfos, report.TotalDevices).Freed
+	return report
+}
@@ -0,0 +1,79 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package fragmentation
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+	utiltesting "tkestack.io/gpu-admission/pkg/util/testing"
+)
+
+func newTestNodeInfo(name string, pods ...*corev1.Pod) *device.NodeInfo {
+	return device.NewNodeInfo(utiltesting.NewNode(name, 2, 16), pods)
+}
+
+func newTestPod(name, cores, memory, index string) *corev1.Pod {
+	pod := utiltesting.NewPod("test-ns", name, utiltesting.NewContainer("container-0", cores, memory))
+	pod.Annotations[util.PredicateGPUIndexPrefix+"0"] = index
+	return pod
+}
+
+func newTestNodeInfos() []*device.NodeInfo {
+	return []*device.NodeInfo{
+		newTestNodeInfo("node0",
+			newTestPod("pod-a", "30", "2", "0"),
+			newTestPod("pod-b", "30", "2", "1")),
+		newTestNodeInfo("node1",
+			newTestPod("pod-c", "50", "4", "0")),
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	report := Analyze(newTestNodeInfos())
+
+	if report.TotalDevices != 4 || report.IdleDevices != 1 || report.PartialDevices != 3 {
+		t.Fatalf("wrong device counts: %+v", report)
+	}
+	if report.StrandedCores != 190 || report.StrandedMemory != 16 {
+		t.Fatalf("wrong stranded resources: %+v", report)
+	}
+	if report.ObtainableDevices != 2 {
+		t.Fatalf("obtainable devices %d, expect 2", report.ObtainableDevices)
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	plan := Recommend(newTestNodeInfos(), 1)
+
+	if plan.Freed != 1 || len(plan.Devices) != 1 {
+		t.Fatalf("expect 1 device freed, got %+v", plan)
+	}
+	if plan.Devices[0] != (DeviceRef{Node: "node0", Index: 0}) {
+		t.Fatalf("wrong freed device %+v", plan.Devices[0])
+	}
+	if len(plan.Moves) != 1 {
+		t.Fatalf("expect 1 move, got %+v", plan.Moves)
+	}
+	move := plan.Moves[0]
+	if move.Pod != "pod-a" || move.ToNode != "node0" || move.Containers[0].ToDevice != 1 {
+		t.Fatalf("wrong move %+v", move)
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package inventory
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"tkestack.io/gpu-admission/pkg/device"
+)
+
+// Lister lists the GPU allocation state of nodes
+type Lister interface {
+	// ListNodeInfos returns the allocation state of GPU nodes which match the selector
+	ListNodeInfos(selector labels.Selector) ([]*device.NodeInfo, error)
+	// GetNodeInfo returns the allocation state of given GPU node
+	GetNodeInfo(name string) (*device.NodeInfo, error)
+}
+
+// NodeList is a list of GPU nodes sorted by name
+type NodeList struct {
+	Items []Node `json:"items"`
+}
+
+// Node describes the GPU inventory and allocation of a node
+type Node struct {
+	Name              string `json:"name"`
+	DeviceCount       int    `json:"deviceCount"`
+	TotalCores        uint   `json:"totalCores"`
+	UsedCores         uint   `json:"usedCores"`
+	AllocatableCores  uint   `json:"allocatableCores"`
+	TotalMemory       uint   `json:"totalMemory"`
+	UsedMemory        uint   `json:"usedMemory"`
+	AllocatableMemory uint   `json:"allocatableMemory"`
+	// CoreOvercommitRatio and MemoryOvercommitRatio are the ratios in effect which
+	// total cores and memory of each device are scaled by
+	CoreOvercommitRatio   float64 `json:"coreOvercommitRatio"`
+	MemoryOvercommitRatio float64 `json:"memoryOvercommitRatio"`
+	// ExpiredReservations is the number of pods predicated to the node but not bound
+	// in time, they don't hold devices any more
+	ExpiredReservations int      `json:"expiredReservations"`
+	Devices             []Device `json:"devices"`
+}
+
+// Device describes the inventory and allocation of a GPU device
+type Device struct {
+	Index int `json:"index"`
+	// State tells if the device can be allocated: Allocatable, Unhealthy or Drained
+	State string `json:"state"`
+	// UUID, Model, NUMANode and Links are known if the node publishes its devices by
+	// GPUNode
+	UUID              string       `json:"uuid,omitempty"`
+	Model             string       `json:"model,omitempty"`
+	NUMANode          int          `json:"numaNode,omitempty"`
+	Links             []Link       `json:"links,omitempty"`
+	TotalCores        uint         `json:"totalCores"`
+	UsedCores         uint         `json:"usedCores"`
+	AllocatableCores  uint         `json:"allocatableCores"`
+	TotalMemory       uint         `json:"totalMemory"`
+	UsedMemory        uint         `json:"usedMemory"`
+	AllocatableMemory uint         `json:"allocatableMemory"`
+	Allocations       []Allocation `json:"allocations"`
+}
+
+// Link describes a connection from a device to another one
+type Link struct {
+	Index int    `json:"index"`
+	Type  string `json:"type"`
+}
+
+// Allocation describes a slice of GPU device held by a container
+type Allocation struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Cores     uint   `json:"cores"`
+	Memory    uint   `json:"memory"`
+}
+
+// NewNodeList converts the allocation state of nodes to a NodeList
+func NewNodeList(nodeInfos []*device.NodeInfo) *NodeList {
+	list := &NodeList{
+		Items: make([]Node, 0, len(nodeInfos)),
+	}
+	for _, nodeInfo := range nodeInfos {
+		list.Items = append(list.Items, NewNode(nodeInfo))
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].Name < list.Items[j].Name
+	})
+	return list
+}
+
+// NewNode converts the allocation state of a node to a Node
+func NewNode(nodeInfo *device.NodeInfo) Node {
+	node := Node{
+		Name:                  nodeInfo.GetName(),
+		DeviceCount:           nodeInfo.GetDeviceCount(),
+		TotalCores:            uint(nodeInfo.GetTotalCore()),
+		UsedCores:             uint(nodeInfo.GetUsedCore()),
+		AllocatableCores:      uint(nodeInfo.GetAvailableCore()),
+		TotalMemory:           uint(nodeInfo.GetTotalMemory()),
+		UsedMemory:            uint(nodeInfo.GetUsedMemory()),
+		AllocatableMemory:     uint(nodeInfo.GetAvailableMemory()),
+		CoreOvercommitRatio:   nodeInfo.GetCoreOvercommitRatio(),
+		MemoryOvercommitRatio: nodeInfo.GetMemoryOvercommitRatio(),
+		ExpiredReservations:   nodeInfo.GetExpiredReservations(),
+		Devices:               make([]Device, 0, nodeInfo.GetDeviceCount()),
+	}
+	for _, dev := range nodeInfo.GetDeviceMap() {
+		node.Devices = append(node.Devices, NewDevice(dev))
+	}
+	sort.Slice(node.Devices, func(i, j int) bool {
+		return node.Devices[i].Index < node.Devices[j].Index
+	})
+	return node
+}
+
+// NewDevice converts the allocation state of a GPU device to a Device
+func NewDevice(dev *device.DeviceInfo) Device {
+	d := Device{
+		Index:             dev.GetID(),
+		State:             string(dev.GetState()),
+		UUID:              dev.GetUUID(),
+		Model:             dev.GetModel(),
+		NUMANode:          dev.GetNUMANode(),
+		TotalCores:        dev.TotalCores(),
+		UsedCores:         dev.UsedCores(),
+		AllocatableCores:  dev.AllocatableCores(),
+		TotalMemory:       dev.TotalMemory(),
+		UsedMemory:        dev.UsedMemory(),
+		AllocatableMemory: dev.AllocatableMemory(),
+		Allocations:       make([]Allocation, 0, len(dev.GetAllocations())),
+	}
+	for _, link := range dev.GetLinks() {
+		d.Links = append(d.Links, Link{Index: link.Index, Type: link.Type})
+	}
+	for _, alloc := range dev.GetAllocations() {
+		d.Allocations = append(d.Allocations, Allocation{
+			Namespace: alloc.Namespace,
+			Pod:       alloc.Pod,
+			Container: alloc.Container,
+			Cores:     alloc.Cores,
+			Memory:    alloc.Memory,
+		})
+	}
+	sort.Slice(d.Allocations, func(i, j int) bool {
+		a, b := d.Allocations[i], d.Allocations[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Pod != b.Pod {
+			return a.Pod < b.Pod
+		}
+		return a.Container < b.Container
+	})
+	return d
+}
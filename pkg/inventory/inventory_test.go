@@ -0,0 +1,102 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package inventory
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+	utiltesting "tkestack.io/gpu-admission/pkg/util/testing"
+)
+
+func newTestNodeInfo(name string, pods ...*corev1.Pod) *device.NodeInfo {
+	return device.NewNodeInfo(utiltesting.NewNode(name, 2, 8), pods)
+}
+
+func newTestPod(name string, index string) *corev1.Pod {
+	pod := utiltesting.NewPod("test-ns", name, utiltesting.NewContainer("container-0", "50", "1"))
+	pod.Annotations[util.PredicateGPUIndexPrefix+"0"] = index
+	return pod
+}
+
+func TestNewNodeList(t *testing.T) {
+	list := NewNodeList([]*device.NodeInfo{
+		newTestNodeInfo("testnode1"),
+		newTestNodeInfo("testnode0", newTestPod("pod-b", "1"), newTestPod("pod-a", "1")),
+	})
+
+	if len(list.Items) != 2 || list.Items[0].Name != "testnode0" || list.Items[1].Name != "testnode1" {
+		t.Fatalf("nodes should be sorted by name: %+v", list.Items)
+	}
+	node := list.Items[0]
+	if node.DeviceCount != 2 || node.TotalCores != 200 || node.UsedCores != 100 ||
+		node.AllocatableCores != 100 || node.TotalMemory != 8 || node.UsedMemory != 2 ||
+		node.AllocatableMemory != 6 {
+		t.Fatalf("wrong resources of node: %+v", node)
+	}
+	if len(node.Devices) != 2 || node.Devices[0].Index != 0 || node.Devices[1].Index != 1 {
+		t.Fatalf("devices should be sorted by index: %+v", node.Devices)
+	}
+	expectAllocations := []Allocation{
+		{Namespace: "test-ns", Pod: "pod-a", Container: "container-0", Cores: 50, Memory: 1},
+		{Namespace: "test-ns", Pod: "pod-b", Container: "container-0", Cores: 50, Memory: 1},
+	}
+	if !reflect.DeepEqual(node.Devices[1].Allocations, expectAllocations) {
+		t.Fatalf("expect allocations %+v, got %+v", expectAllocations, node.Devices[1].Allocations)
+	}
+}
+
+func TestNodeJSON(t *testing.T) {
+	node := NewNode(newTestNodeInfo("testnode0", newTestPod("pod-a", "0")))
+	data, err := json.Marshal(node)
+	if err != nil {
+		t.Fatalf("failed to marshal node: %v", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("failed to unmarshal node: %v", err)
+	}
+	for _, key := range []string{"name", "deviceCount", "totalCores", "usedCores",
+		"allocatableCores", "totalMemory", "usedMemory", "allocatableMemory",
+		"coreOvercommitRatio", "memoryOvercommitRatio", "expiredReservations", "devices"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("field %s is missing in %s", key, data)
+		}
+	}
+	devices := fields["devices"].([]interface{})
+	idle := devices[1].(map[string]interface{})
+	// allocations of idle devices are empty instead of null, and inventory of devices
+	// not published by GPUNode is omitted
+	if allocations, ok := idle["allocations"].([]interface{}); !ok || len(allocations) != 0 {
+		t.Errorf("allocations of idle device should be an empty list: %s", data)
+	}
+	for _, key := range []string{"uuid", "model", "links"} {
+		if _, ok := idle[key]; ok {
+			t.Errorf("field %s should be omitted: %s", key, data)
+		}
+	}
+
+	var decoded Node
+	if err := json.Unmarshal(data, &decoded); err != nil || !reflect.DeepEqual(decoded, node) {
+		t.Errorf("node doesn't survive a round trip: %+v, %v", decoded, err)
+	}
+}
@@ -0,0 +1,270 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog"
+)
+
+// ErrNotLeader is returned by a standby which doesn't know the leader, the scheduler
+// should retry later
+var ErrNotLeader = errors.New("gpu-admission is not the leader and the leader is unknown, retry later")
+
+// Config configures the Lease based leader election
+type Config struct {
+	// Namespace and Name of the Lease object
+	Namespace string
+	Name      string
+	// Identity is the address where other instances reach this one, it's recorded as
+	// the holder of Lease, so standbys can proxy requests to the leader
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+	// Handoff runs after acquiring the Lease and before serving as the leader. It's
+	// retried until it succeeds or the leadership is lost.
+	Handoff func(ctx context.Context) error
+}
+
+// ValidateIdentity checks the identity is an address other instances can reach, so
+// every instance has its own identity. Loopback and unspecified hosts are rejected.
+func ValidateIdentity(identity string) error {
+	host, port, err := net.SplitHostPort(identity)
+	if err != nil {
+		return fmt.Errorf("identity %q is not an address: %v", identity, err)
+	}
+	if host == "" || port == "" {
+		return fmt.Errorf("identity %q has no host or port", identity)
+	}
+	if host == "localhost" {
+		return fmt.Errorf("identity %q is a loopback address", identity)
+	}
+	if ip := net.ParseIP(host); ip != nil && (ip.IsLoopback() || ip.IsUnspecified()) {
+		return fmt.Errorf("identity %q is a loopback or unspecified address", identity)
+	}
+	return nil
+}
+
+// Elector runs leader election and tells if this instance is the leader
+type Elector struct {
+	config Config
+	lock   resourcelock.Interface
+	// leading is set to 1 after the handoff succeeds, and reset when the Lease is lost
+	leading int32
+	// leader is the identity of the last observed leader
+	mu     sync.RWMutex
+	leader string
+
+	// servingMu guards the leadership of requests. leadCtx is cancelled once the
+	// leadership is lost, released is set when the Lease is being given up in
+	// current term, and inflight counts requests served as the leader.
+	servingMu     sync.Mutex
+	leadCtx       context.Context
+	cancelLeading context.CancelFunc
+	released      bool
+	inflight      int32
+}
+
+// NewElector returns an Elector holding the Lease by given client
+func NewElector(client kubernetes.Interface, config Config) (*Elector, error) {
+	if config.Identity == "" {
+		return nil, errors.New("identity of leader election is required")
+	}
+	e := &Elector{config: config}
+	e.lock = &drainingLock{
+		Interface: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Namespace: config.Namespace,
+				Name:      config.Name,
+			},
+			Client: client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity: config.Identity,
+			},
+		},
+		elector: e,
+	}
+	return e, nil
+}
+
+// drainingLock stops serving and drains in-flight requests before the Lease is
+// handed to nobody, so no request is served as the leader after it's released
+type drainingLock struct {
+	resourcelock.Interface
+	elector *Elector
+}
+
+func (l *drainingLock) Update(ctx context.Context, record resourcelock.LeaderElectionRecord) error {
+	if record.HolderIdentity != l.elector.config.Identity {
+		l.elector.stopServing()
+	}
+	return l.Interface.Update(ctx, record)
+}
+
+// Run campaigns for the Lease until ctx is done. The Lease is released when ctx is
+// done, so a standby takes over without waiting for the Lease to expire.
+func (e *Elector) Run(ctx context.Context) {
+	for {
+		e.servingMu.Lock()
+		e.released = false
+		e.servingMu.Unlock()
+		le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock:            e.lock,
+			LeaseDuration:   e.config.LeaseDuration,
+			RenewDeadline:   e.config.RenewDeadline,
+			RetryPeriod:     e.config.RetryPeriod,
+			ReleaseOnCancel: true,
+			Name:            e.config.Name,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: e.startLeading,
+				OnStoppedLeading: e.stopLeading,
+				OnNewLeader:      e.setLeader,
+			},
+		})
+		if err != nil {
+			klog.Fatalf("Invalid leader election config: %v", err)
+		}
+		// Run returns after the Lease is lost, then campaign again as a standby
+		le.Run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (e *Elector) startLeading(ctx context.Context) {
+	klog.Infof("%s acquired the lease %s/%s", e.config.Identity, e.config.Namespace,
+		e.config.Name)
+	if e.config.Handoff != nil {
+		err := wait.PollImmediateUntil(e.config.RetryPeriod, func() (bool, error) {
+			if err := e.config.Handoff(ctx); err != nil {
+				klog.Infof("handoff of leader failed, retry: %v", err)
+				return false, nil
+			}
+			return true, nil
+		}, ctx.Done())
+		if err != nil {
+			return
+		}
+	}
+	e.servingMu.Lock()
+	defer e.servingMu.Unlock()
+	// the Lease may be released before ctx is cancelled
+	if ctx.Err() != nil || e.released {
+		return
+	}
+	e.leadCtx, e.cancelLeading = context.WithCancel(ctx)
+	atomic.StoreInt32(&e.leading, 1)
+	klog.Infof("%s starts serving as the leader", e.config.Identity)
+}
+
+func (e *Elector) stopLeading() {
+	e.stopServing()
+}
+
+// stopServing cancels requests served as the leader and waits for them to finish.
+// The Lease is renewed at most RetryPeriod+RenewDeadline before it's lost, so the
+// wait is bounded by the rest of LeaseDuration, after which a standby may take over.
+func (e *Elector) stopServing() {
+	e.servingMu.Lock()
+	e.released = true
+	if e.cancelLeading != nil {
+		e.cancelLeading()
+		e.leadCtx, e.cancelLeading = nil, nil
+	}
+	stopped := atomic.SwapInt32(&e.leading, 0) == 1
+	e.servingMu.Unlock()
+	if !stopped {
+		return
+	}
+
+	timeout := e.config.LeaseDuration - e.config.RenewDeadline - e.config.RetryPeriod
+	if timeout <= 0 {
+		timeout = e.config.RetryPeriod
+	}
+	err := wait.PollImmediate(10*time.Millisecond, timeout, func() (bool, error) {
+		return atomic.LoadInt32(&e.inflight) == 0, nil
+	})
+	if err != nil {
+		klog.Errorf("%s stops serving as the leader with %d requests in flight",
+			e.config.Identity, atomic.LoadInt32(&e.inflight))
+		return
+	}
+	klog.Infof("%s stops serving as the leader", e.config.Identity)
+}
+
+// Serve serves a request as the leader if this instance is the leader. The request
+// should use the returned context, which is cancelled once the leadership is lost,
+// and call done after it's finished. The Lease is not released until the in-flight
+// requests are done.
+func (e *Elector) Serve(ctx context.Context) (context.Context, func(), bool) {
+	e.servingMu.Lock()
+	defer e.servingMu.Unlock()
+	if e.leadCtx == nil {
+		return nil, nil, false
+	}
+	leadCtx := e.leadCtx
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-leadCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	atomic.AddInt32(&e.inflight, 1)
+	return ctx, func() {
+		cancel()
+		atomic.AddInt32(&e.inflight, -1)
+	}, true
+}
+
+func (e *Elector) setLeader(identity string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.leader = identity
+	klog.Infof("new leader %s is observed", identity)
+}
+
+// IsLeader tells if this instance holds the Lease and has finished the handoff
+func (e *Elector) IsLeader() bool {
+	return atomic.LoadInt32(&e.leading) == 1
+}
+
+// Leader returns the identity of the leader, it's empty if the leader is unknown or
+// this instance is the leader but hasn't finished the handoff
+func (e *Elector) Leader() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.leader == e.config.Identity {
+		return ""
+	}
+	return e.leader
+}
@@ -0,0 +1,159 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package leader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestConfig(identity string, handoff func(ctx context.Context) error) Config {
+	return Config{
+		Namespace:     "kube-system",
+		Name:          "gpu-admission",
+		Identity:      identity,
+		LeaseDuration: 2 * time.Second,
+		RenewDeadline: time.Second,
+		RetryPeriod:   100 * time.Millisecond,
+		Handoff:       handoff,
+	}
+}
+
+func TestElector(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	handoffs := 0
+	handoff := func(ctx context.Context) error {
+		handoffs++
+		if handoffs < 2 {
+			return errors.New("cache is not synced")
+		}
+		return nil
+	}
+
+	first, err := NewElector(client, newTestConfig("10.0.0.1:3456", handoff))
+	if err != nil {
+		t.Fatalf("failed to create elector: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		first.Run(ctx)
+		close(stopped)
+	}()
+	err = wait.PollImmediate(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return first.IsLeader(), nil
+	})
+	if err != nil || handoffs != 2 {
+		t.Fatalf("first elector should lead after handoff succeeds, handoffs: %d", handoffs)
+	}
+
+	second, _ := NewElector(client, newTestConfig("10.0.0.2:3456", nil))
+	secondCtx, secondCancel := context.WithCancel(context.Background())
+	defer secondCancel()
+	go second.Run(secondCtx)
+	err = wait.PollImmediate(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return second.Leader() == "10.0.0.1:3456", nil
+	})
+	if err != nil || second.IsLeader() {
+		t.Fatalf("second elector should be a standby of 10.0.0.1:3456, leader %q",
+			second.Leader())
+	}
+
+	// the Lease is released on stop, so the standby takes over
+	cancel()
+	<-stopped
+	if first.IsLeader() {
+		t.Fatalf("stopped elector should not lead")
+	}
+	err = wait.PollImmediate(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return second.IsLeader(), nil
+	})
+	if err != nil || second.Leader() != "" {
+		t.Fatalf("second elector should take over, leader %q", second.Leader())
+	}
+}
+
+func TestElectorDrain(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	first, _ := NewElector(client, newTestConfig("10.0.0.1:3456", nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		first.Run(ctx)
+		close(stopped)
+	}()
+	err := wait.PollImmediate(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return first.IsLeader(), nil
+	})
+	if err != nil {
+		t.Fatalf("first elector should lead")
+	}
+	reqCtx, done, ok := first.Serve(context.Background())
+	if !ok {
+		t.Fatalf("leader should serve requests")
+	}
+
+	second, _ := NewElector(client, newTestConfig("10.0.0.2:3456", nil))
+	secondCtx, secondCancel := context.WithCancel(context.Background())
+	defer secondCancel()
+	go second.Run(secondCtx)
+
+	// the in-flight request is cancelled, and the Lease is held until it's done
+	cancel()
+	select {
+	case <-reqCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("request should be cancelled once the leadership is lost")
+	}
+	if _, _, ok := first.Serve(context.Background()); ok {
+		t.Fatalf("elector giving up the Lease should not serve requests")
+	}
+	time.Sleep(300 * time.Millisecond)
+	if second.IsLeader() {
+		t.Fatalf("Lease should not be released with requests in flight")
+	}
+	done()
+	<-stopped
+	err = wait.PollImmediate(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return second.IsLeader(), nil
+	})
+	if err != nil {
+		t.Fatalf("second elector should take over after the request is done")
+	}
+}
+
+func TestValidateIdentity(t *testing.T) {
+	for identity, valid := range map[string]bool{
+		"10.0.0.1:3456":      true,
+		"gpu-admission:3456": true,
+		"127.0.0.1:3456":     false,
+		"localhost:3456":     false,
+		"0.0.0.0:3456":       false,
+		"[::]:3456":          false,
+		":3456":              false,
+		"10.0.0.1":           false,
+	} {
+		if err := ValidateIdentity(identity); (err == nil) != valid {
+			t.Errorf("identity %s should be valid: %t, got %v", identity, valid, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package metrics
+
+import (
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/inventory"
+)
+
+// unknownModel is the model of devices not published by GPUNode
+const unknownModel = "unknown"
+
+var (
+	coresDesc = metrics.NewDesc(namespace+"_vcuda_cores",
+		"vcuda cores of GPU devices by node, model and type (total, used or free)",
+		[]string{"node", "model", "type"}, nil, metrics.ALPHA, "")
+	memoryDesc = metrics.NewDesc(namespace+"_vcuda_memory",
+		"vcuda memory of GPU devices by node, model and type (total, used or free)",
+		[]string{"node", "model", "type"}, nil, metrics.ALPHA, "")
+	idleDevicesDesc = metrics.NewDesc(namespace+"_idle_devices",
+		"Number of allocatable GPU devices without any allocation by node and model",
+		[]string{"node", "model"}, nil, metrics.ALPHA, "")
+	expiredReservationsDesc = metrics.NewDesc(namespace+"_expired_reservations",
+		"Number of pods predicated to the node whose reservations have expired",
+		[]string{"node"}, nil, metrics.ALPHA, "")
+)
+
+// clusterCollector collects the GPU allocation state of nodes on scraping
+type clusterCollector struct {
+	metrics.BaseStableCollector
+
+	lister inventory.Lister
+}
+
+// RegisterClusterCollector registers the gauges of GPU allocation state of nodes
+// listed by lister
+func RegisterClusterCollector(lister inventory.Lister) {
+	legacyregistry.CustomMustRegister(&clusterCollector{lister: lister})
+}
+
+func (c *clusterCollector) DescribeWithStability(ch chan<- *metrics.Desc) {
+	ch <- coresDesc
+	ch <- memoryDesc
+	ch <- idleDevicesDesc
+	ch <- expiredReservationsDesc
+}
+
+func (c *clusterCollector) CollectWithStability(ch chan<- metrics.Metric) {
+	nodeInfos, err := c.lister.ListNodeInfos(labels.Everything())
+	if err != nil {
+		klog.Errorf("failed to list nodes for metrics: %v", err)
+		return
+	}
+	for _, nodeInfo := range nodeInfos {
+		for model, s := range statsByModel(nodeInfo) {
+			for typ, value := range map[string]uint{
+				"total": s.totalCores, "used": s.usedCores, "free": s.freeCores} {
+				ch <- metrics.NewLazyConstMetric(coresDesc, metrics.GaugeValue, float64(value),
+					nodeInfo.GetName(), model, typ)
+			}
+			for typ, value := range map[string]uint{
+				"total": s.totalMemory, "used": s.usedMemory, "free": s.freeMemory} {
+				ch <- metrics.NewLazyConstMetric(memoryDesc, metrics.GaugeValue, float64(value),
+					nodeInfo.GetName(), model, typ)
+			}
+			ch <- metrics.NewLazyConstMetric(idleDevicesDesc, metrics.GaugeValue,
+				float64(s.idleDevices), nodeInfo.GetName(), model)
+		}
+		ch <- metrics.NewLazyConstMetric(expiredReservationsDesc, metrics.GaugeValue,
+			float64(nodeInfo.GetExpiredReservations()), nodeInfo.GetName())
+	}
+}
+
+type modelStats struct {
+	totalCores, usedCores, freeCores    uint
+	totalMemory, usedMemory, freeMemory uint
+	idleDevices                         int
+}
+
+// statsByModel sums the resources of devices of the node by model, free resources
+// are only counted on allocatable devices
+func statsByModel(nodeInfo *device.NodeInfo) map[string]*modelStats {
+	ret := make(map[string]*modelStats)
+	for _, dev := range nodeInfo.GetDeviceMap() {
+		model := dev.GetModel()
+		if model == "" {
+			model = unknownModel
+		}
+		s, ok := ret[model]
+		if !ok {
+			s = &modelStats{}
+			ret[model] = s
+		}
+		s.totalCores += dev.TotalCores()
+		s.usedCores += dev.UsedCores()
+		s.totalMemory += dev.TotalMemory()
+		s.usedMemory += dev.UsedMemory()
+		if dev.IsAllocatable() {
+			s.freeCores += dev.AllocatableCores()
+			s.freeMemory += dev.AllocatableMemory()
+			if dev.IsIdle() {
+				s.idleDevices++
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,84 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package metrics
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	gpuv1alpha1 "tkestack.io/gpu-admission/pkg/apis/gpu/v1alpha1"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+func TestStatsByModel(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "testnode"},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{
+				util.VCoreAnnotation:   resource.MustParse("300"),
+				util.VMemoryAnnotation: resource.MustParse("12"),
+			},
+		},
+	}
+	gpuNode := &gpuv1alpha1.GPUNode{
+		ObjectMeta: metav1.ObjectMeta{Name: "testnode"},
+		Spec: gpuv1alpha1.GPUNodeSpec{
+			Devices: []gpuv1alpha1.GPUDevice{
+				{Index: 0, Model: "V100", Memory: 4},
+				{Index: 1, Model: "V100", Memory: 4},
+				{Index: 2, Memory: 4, Health: gpuv1alpha1.GPUDeviceUnhealthy},
+			},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pod-0",
+			Annotations: map[string]string{
+				util.PredicateGPUIndexPrefix + "0": "0",
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: "testnode",
+			Containers: []corev1.Container{{
+				Name: "container-0",
+				Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{
+						util.VCoreAnnotation:   resource.MustParse("30"),
+						util.VMemoryAnnotation: resource.MustParse("1"),
+					},
+				},
+			}},
+		},
+	}
+
+	stats := statsByModel(device.NewNodeInfo(node, []*corev1.Pod{pod}, device.WithGPUNode(gpuNode)))
+
+	v100 := stats["V100"]
+	if v100 == nil || v100.totalCores != 200 || v100.usedCores != 30 || v100.freeCores != 170 ||
+		v100.freeMemory != 7 || v100.idleDevices != 1 {
+		t.Errorf("wrong stats of V100: %+v", v100)
+	}
+	unknown := stats[unknownModel]
+	if unknown == nil || unknown.totalCores != 100 || unknown.freeCores != 0 ||
+		unknown.idleDevices != 0 {
+		t.Errorf("wrong stats of unknown model: %+v", unknown)
+	}
+}
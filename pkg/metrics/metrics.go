@@ -0,0 +1,124 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const (
+	namespace = "gpu_admission"
+
+	// stages of filter
+	StageListPods      = "list_pods"
+	StageBuildNodeInfo = "build_node_info"
+	StageAllocate      = "allocate"
+	StagePatch         = "patch"
+
+	// modes of predicated pods
+	ModeShared    = "shared"
+	ModeExclusive = "exclusive"
+
+	// results of predication
+	ResultPredicated = "predicated"
+	ResultUnfit      = "unfit"
+	ResultError      = "error"
+	// ResultDeferred is the result of pods placed without reservations, which are
+	// made when the pods are bound
+	ResultDeferred = "deferred"
+)
+
+var (
+	// FilterLatency is the latency of filter requests
+	FilterLatency = metrics.NewHistogram(
+		&metrics.HistogramOpts{
+			Namespace:      namespace,
+			Name:           "filter_duration_seconds",
+			Help:           "Latency of filter requests in seconds",
+			Buckets:        metrics.ExponentialBuckets(0.001, 2, 15),
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	// FilterStageLatency is the latency of each stage of filter, the stages of
+	// every node are observed separately
+	FilterStageLatency = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      namespace,
+			Name:           "filter_stage_duration_seconds",
+			Help:           "Latency of each stage of filter in seconds",
+			Buckets:        metrics.ExponentialBuckets(0.0001, 2, 15),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"stage"},
+	)
+
+	// PredicatedPods counts the pods filtered by mode and result
+	PredicatedPods = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      namespace,
+			Name:           "predicated_pods_total",
+			Help:           "Number of pods filtered by mode (shared or exclusive) and result",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"mode", "result"},
+	)
+
+	// APIBreakerState is the state of the circuit breaker around API server calls
+	APIBreakerState = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Namespace:      namespace,
+			Name:           "api_breaker_state",
+			Help:           "State of the circuit breaker around API server calls, 0 closed, 1 half-open, 2 open",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	// PatchRetries counts the retries of patching pods
+	PatchRetries = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Namespace:      namespace,
+			Name:           "patch_retries_total",
+			Help:           "Number of retries of patching predication annotations to pods",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+)
+
+var registerOnce sync.Once
+
+// Register registers the metrics of filter
+func Register() {
+	registerOnce.Do(func() {
+		legacyregistry.MustRegister(
+			FilterLatency,
+			FilterStageLatency,
+			PredicatedPods,
+			PatchRetries,
+			APIBreakerState,
+		)
+	})
+}
+
+// ObserveStage records the latency of a stage started at given time
+func ObserveStage(stage string, start time.Time) {
+	FilterStageLatency.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
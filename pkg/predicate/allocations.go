@@ -0,0 +1,183 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog"
+
+	gpuv1alpha1 "tkestack.io/gpu-admission/pkg/apis/gpu/v1alpha1"
+	"tkestack.io/gpu-admission/pkg/client/clientset/versioned"
+	gpuinformers "tkestack.io/gpu-admission/pkg/client/informers/externalversions"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// WithGPUAllocationRecord records allocations of predicated pods in GPUAllocation
+// objects besides the pod annotations, and rebuilds the allocation state of nodes
+// from these objects
+func WithGPUAllocationRecord(client versioned.Interface) Option {
+	return func(gpuFilter *GPUFilter) {
+		gpuFilter.gpuClient = client
+		gpuFilter.allocationRecord = true
+	}
+}
+
+// WithGPUNodeInventory builds the devices of nodes from their GPUNode objects if
+// they exist
+func WithGPUNodeInventory(client versioned.Interface) Option {
+	return func(gpuFilter *GPUFilter) {
+		gpuFilter.gpuClient = client
+		gpuFilter.gpuNodeInventory = true
+	}
+}
+
+// startGPUInformers starts watching the GPU objects which are used
+func (gpuFilter *GPUFilter) startGPUInformers() {
+	if gpuFilter.gpuClient == nil {
+		return
+	}
+	informerFactory := gpuinformers.NewSharedInformerFactory(gpuFilter.gpuClient,
+		time.Second*30)
+	if gpuFilter.allocationRecord {
+		informer := informerFactory.Gpu().V1alpha1().GPUAllocations()
+		gpuFilter.allocationLister = informer.Lister()
+		gpuFilter.cacheSyncs = append(gpuFilter.cacheSyncs, informer.Informer().HasSynced)
+	}
+	if gpuFilter.gpuNodeInventory {
+		informer := informerFactory.Gpu().V1alpha1().GPUNodes()
+		gpuFilter.gpuNodeLister = informer.Lister()
+		gpuFilter.cacheSyncs = append(gpuFilter.cacheSyncs, informer.Informer().HasSynced)
+	}
+	go informerFactory.Start(gpuFilter.stopCh)
+}
+
+// newNodeInfo builds the allocation state of node from the pods on it, together with
+// the GPUAllocation and GPUNode objects if they are used
+func (gpuFilter *GPUFilter) newNodeInfo(node *corev1.Node, pods []*corev1.Pod) *device.NodeInfo {
+	opts := gpuFilter.nodeInfoOptions
+	opts = opts[:len(opts):len(opts)]
+	if gpuFilter.allocationLister != nil {
+		allocations, err := gpuFilter.allocationLister.List(labels.SelectorFromSet(
+			labels.Set{util.PredicateNode: node.Name}))
+		if err != nil {
+			klog.Infof("failed to list GPU allocations of node %s: %v", node.Name, err)
+		} else {
+			opts = append(opts, device.WithGPUAllocations(allocations))
+		}
+	}
+	if gpuFilter.gpuNodeLister != nil {
+		gpuNode, err := gpuFilter.gpuNodeLister.Get(node.Name)
+		switch {
+		case err == nil:
+			opts = append(opts, device.WithGPUNode(gpuNode))
+		case !apierrors.IsNotFound(err):
+			klog.Infof("failed to get GPUNode %s: %v", node.Name, err)
+		}
+	}
+	return device.NewNodeInfo(node, pods, opts...)
+}
+
+// recordAllocations creates or updates the GPUAllocation objects of the GPU containers
+// of a predicated pod. The annotations are still the record read by gpu-manager, so
+// failures are only logged.
+func (gpuFilter *GPUFilter) recordAllocations(pod *corev1.Pod, nodeName string) {
+	if !gpuFilter.allocationRecord {
+		return
+	}
+	for i, c := range pod.Spec.Containers {
+		if !util.IsGPURequiredContainer(&c) {
+			continue
+		}
+		indexes, err := util.GetPredicateIdxOfContainer(pod, i)
+		if err != nil {
+			klog.Infof("failed to record GPU allocation of pod %s/%s: %v", pod.Namespace,
+				pod.Name, err)
+			continue
+		}
+		alloc := newGPUAllocation(pod, i, nodeName, indexes)
+		if err := gpuFilter.saveGPUAllocation(alloc); err != nil {
+			klog.Infof("failed to record GPU allocation %s/%s: %v", alloc.Namespace,
+				alloc.Name, err)
+		}
+	}
+}
+
+func (gpuFilter *GPUFilter) saveGPUAllocation(alloc *gpuv1alpha1.GPUAllocation) error {
+	client := gpuFilter.gpuClient.GpuV1alpha1().GPUAllocations(alloc.Namespace)
+	_, err := client.Create(context.Background(), alloc, metav1.CreateOptions{})
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	// the pod is predicated again, e.g. its reservation expired
+	existing, err := client.Get(context.Background(), alloc.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	existing = existing.DeepCopy()
+	existing.Labels = alloc.Labels
+	existing.OwnerReferences = alloc.OwnerReferences
+	existing.Spec = alloc.Spec
+	existing.Status = alloc.Status
+	_, err = client.Update(context.Background(), existing, metav1.UpdateOptions{})
+	return err
+}
+
+// newGPUAllocation returns the GPUAllocation of a container of pod, which is owned by
+// the pod and named by pod name and container index
+func newGPUAllocation(pod *corev1.Pod, containerIndex int, nodeName string,
+	indexes []int) *gpuv1alpha1.GPUAllocation {
+	c := &pod.Spec.Containers[containerIndex]
+	predicateTime, err := util.GetPredicateTimeOfPod(pod)
+	if err != nil {
+		klog.Infof("%v, record the current time instead", err)
+		predicateTime = time.Now()
+	}
+	return &gpuv1alpha1.GPUAllocation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%d", pod.Name, containerIndex),
+			Namespace: pod.Namespace,
+			Labels: map[string]string{
+				util.PredicateNode: nodeName,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(pod, corev1.SchemeGroupVersion.WithKind("Pod")),
+			},
+		},
+		Spec: gpuv1alpha1.GPUAllocationSpec{
+			PodName:        pod.Name,
+			PodUID:         pod.UID,
+			Container:      c.Name,
+			ContainerIndex: containerIndex,
+			NodeName:       nodeName,
+			DeviceIndexes:  indexes,
+			Cores:          int64(util.GetGPUCoresOfContainer(c)),
+			Memory:         int64(util.GetGPUResourceOfContainer(c, util.VMemoryAnnotation)),
+		},
+		Status: gpuv1alpha1.GPUAllocationStatus{
+			Phase:         gpuv1alpha1.GPUAllocationReserved,
+			PredicateTime: metav1.NewTime(predicateTime),
+		},
+	}
+}
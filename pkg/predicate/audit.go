@@ -0,0 +1,116 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/audit"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/metrics"
+)
+
+// WithAuditLogger writes each filter decision to logger
+func WithAuditLogger(logger *audit.Logger) Option {
+	return func(gpuFilter *GPUFilter) {
+		gpuFilter.auditLogger = logger
+	}
+}
+
+// auditPredication writes the decision made by predicate, outcome is the result if
+// the predication isn't aborted
+func (gpuFilter *GPUFilter) auditPredication(pod *corev1.Pod, result *predicateResult,
+	outcome string) {
+	if gpuFilter.auditLogger == nil {
+		return
+	}
+	record := audit.NewRecord(pod)
+	record.Candidates = result.candidates
+	record.Order = result.order
+	record.FailedNodes = result.failedNodes
+	switch {
+	case result.err != nil:
+		record.Result = metrics.ResultError
+		record.Error = result.err.Error()
+	case result.node != nil:
+		record.Result = outcome
+		record.SetPlacement(result.node.Name, result.pod)
+	default:
+		record.Result = metrics.ResultUnfit
+	}
+	gpuFilter.auditLogger.Log(record)
+}
+
+// auditFailure writes the decision made without predication, e.g. the pod had been
+// predicated or the API server is degraded
+func (gpuFilter *GPUFilter) auditFailure(pod *corev1.Pod, failedNodes extenderv1.FailedNodesMap,
+	err error) {
+	if gpuFilter.auditLogger == nil {
+		return
+	}
+	record := audit.NewRecord(pod)
+	record.FailedNodes = failedNodes
+	record.Result = metrics.ResultUnfit
+	if err != nil {
+		record.Result = metrics.ResultError
+		record.Error = err.Error()
+	}
+	gpuFilter.auditLogger.Log(record)
+}
+
+// auditPlacements writes the decision of each member of a pod group admitted at once,
+// candidates are the state of nodes before the members are placed
+func (gpuFilter *GPUFilter) auditPlacements(placements []placement,
+	candidates []audit.Candidate) {
+	if gpuFilter.auditLogger == nil {
+		return
+	}
+	for _, placement := range placements {
+		record := audit.NewRecord(placement.pod)
+		record.Candidates = candidates
+		record.Result = metrics.ResultPredicated
+		record.SetPlacement(placement.node.Name, placement.pod)
+		gpuFilter.auditLogger.Log(record)
+	}
+}
+
+// auditReservation writes the decision of a member of pod group filtered to the node
+// reserved when the group was admitted
+func (gpuFilter *GPUFilter) auditReservation(pod *corev1.Pod, nodeName string,
+	failedNodes extenderv1.FailedNodesMap) {
+	if gpuFilter.auditLogger == nil {
+		return
+	}
+	record := audit.NewRecord(pod)
+	record.FailedNodes = failedNodes
+	record.Result = metrics.ResultPredicated
+	record.SetPlacement(nodeName, pod)
+	gpuFilter.auditLogger.Log(record)
+}
+
+// auditCandidates returns the state of nodes if decisions are audited
+func (gpuFilter *GPUFilter) auditCandidates(nodeInfoList []*device.NodeInfo) []audit.Candidate {
+	if gpuFilter.auditLogger == nil {
+		return nil
+	}
+	candidates := make([]audit.Candidate, 0, len(nodeInfoList))
+	for _, nodeInfo := range nodeInfoList {
+		candidates = append(candidates, audit.NewCandidate(nodeInfo))
+	}
+	return candidates
+}
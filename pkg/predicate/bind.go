@@ -0,0 +1,237 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/breaker"
+	"tkestack.io/gpu-admission/pkg/metrics"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// FailurePolicy tells how pods are filtered while the API server is degraded, i.e. the
+// circuit breaker around API server calls is open
+type FailurePolicy string
+
+const (
+	// FailClosed fails every node with a retryable reason, so the scheduler backs off
+	FailClosed FailurePolicy = "fail-closed"
+	// FailOpen places pods by read-only feasibility without reservations, which are
+	// made when the pods are bound by the bind endpoint
+	FailOpen FailurePolicy = "fail-open"
+)
+
+// defaultBreakerConfig opens the breaker after 5 consecutive failures
+var defaultBreakerConfig = breaker.Config{
+	FailureThreshold: 5,
+	Cooldown:         5 * time.Second,
+	MaxCooldown:      time.Minute,
+}
+
+// WithFailurePolicy sets the policy while the API server is degraded, and the breaker
+// telling if it's degraded
+func WithFailurePolicy(policy FailurePolicy, config breaker.Config) Option {
+	return func(gpuFilter *GPUFilter) {
+		gpuFilter.failurePolicy = policy
+		gpuFilter.breakerConfig = config
+	}
+}
+
+// newAPIBreaker returns the breaker around API server calls, its state is exported
+// as metrics
+func newAPIBreaker(config breaker.Config) *breaker.Breaker {
+	onStateChange := config.OnStateChange
+	config.OnStateChange = func(state breaker.State) {
+		metrics.APIBreakerState.Set(float64(state))
+		if onStateChange != nil {
+			onStateChange(state)
+		}
+	}
+	return breaker.New(config)
+}
+
+// callAPI calls the API server through the breaker, only the failures caused by the
+// API server are counted
+func (gpuFilter *GPUFilter) callAPI(call func() error) error {
+	if err := gpuFilter.apiBreaker.Allow(); err != nil {
+		return err
+	}
+	err := call()
+	if isAPIFailure(err) {
+		gpuFilter.apiBreaker.Done(err)
+	} else {
+		gpuFilter.apiBreaker.Done(nil)
+	}
+	return err
+}
+
+// isAPIFailure tells if err means the API server is degraded, rather than the request
+// is invalid, or cancelled or timed out by the caller
+func isAPIFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if _, ok := err.(apierrors.APIStatus); !ok {
+		// the API server is not reachable
+		return true
+	}
+	return apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) ||
+		apierrors.IsTooManyRequests(err) || apierrors.IsInternalError(err) ||
+		apierrors.IsServiceUnavailable(err) || apierrors.IsUnexpectedServerError(err)
+}
+
+// degraded tells if the API server is degraded
+func (gpuFilter *GPUFilter) degraded() bool {
+	return gpuFilter.apiBreaker.State() == breaker.Open
+}
+
+// failClosed returns every node failed because the API server is degraded
+func failClosed(nodes []corev1.Node) *extenderv1.ExtenderFilterResult {
+	failedNodes := make(extenderv1.FailedNodesMap)
+	for _, node := range nodes {
+		failedNodes[node.Name] = breaker.ErrOpen.Error()
+	}
+	return &extenderv1.ExtenderFilterResult{
+		Nodes:       &corev1.NodeList{},
+		FailedNodes: failedNodes,
+	}
+}
+
+// deferredFilter chooses the node by read-only feasibility while the API server is
+// degraded, the pod is not patched until it's bound
+func (gpuFilter *GPUFilter) deferredFilter(ctx context.Context,
+	pod *corev1.Pod, nodes []corev1.Node) ([]corev1.Node, extenderv1.FailedNodesMap, error) {
+	var filteredNodes = make([]corev1.Node, 0)
+	if group, ok := pod.Annotations[util.PodGroupAnnotation]; ok {
+		// members of a pod group can't be admitted all or nothing without reservations
+		failedNodes := make(extenderv1.FailedNodesMap)
+		for _, node := range nodes {
+			failedNodes[node.Name] = fmt.Sprintf("pod group %s can't be admitted: %v", group,
+				breaker.ErrOpen)
+		}
+		gpuFilter.auditFailure(pod, failedNodes, nil)
+		return filteredNodes, failedNodes, nil
+	}
+
+	result := gpuFilter.predicate(ctx, pod, nodes, true)
+	gpuFilter.auditPredication(pod, result, metrics.ResultDeferred)
+	if result.err != nil {
+		return filteredNodes, make(extenderv1.FailedNodesMap), result.err
+	}
+	if result.node != nil {
+		klog.Infof("pod %s/%s is placed to node %s without reservation as the API server "+
+			"is degraded", pod.Namespace, pod.Name, result.node.Name)
+		filteredNodes = append(filteredNodes, *result.node)
+	}
+	return filteredNodes, result.failedNodes, nil
+}
+
+// Bind binds the pod to the node. If the reservation of pod was deferred because the
+// API server was degraded, devices are allocated on the node and patched to the pod
+// before binding.
+func (gpuFilter *GPUFilter) Bind(ctx context.Context,
+	args extenderv1.ExtenderBindingArgs) *extenderv1.ExtenderBindingResult {
+	if err := gpuFilter.bind(ctx, args); err != nil {
+		klog.Infof("failed to bind pod %s/%s to node %s: %v", args.PodNamespace,
+			args.PodName, args.Node, err)
+		return &extenderv1.ExtenderBindingResult{Error: err.Error()}
+	}
+	return &extenderv1.ExtenderBindingResult{}
+}
+
+func (gpuFilter *GPUFilter) bind(ctx context.Context, args extenderv1.ExtenderBindingArgs) error {
+	if err := gpuFilter.Ready(); err != nil {
+		return err
+	}
+	pod, err := gpuFilter.podLister.Pods(args.PodNamespace).Get(args.PodName)
+	if err != nil {
+		return err
+	}
+	if pod.UID != args.PodUID {
+		return fmt.Errorf("pod %s/%s has been recreated", args.PodNamespace, args.PodName)
+	}
+
+	if util.IsGPURequiredPod(pod) && !gpuFilter.reservedOn(pod, args.Node) {
+		// the cache may not have observed the reservation made by filter yet
+		err := gpuFilter.callAPI(func() error {
+			var err error
+			pod, err = gpuFilter.kubeClient.CoreV1().Pods(args.PodNamespace).Get(ctx,
+				args.PodName, metav1.GetOptions{})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !gpuFilter.reservedOn(pod, args.Node) {
+			if err := gpuFilter.reserve(ctx, pod, args.Node); err != nil {
+				return err
+			}
+		}
+	}
+
+	binding := &corev1.Binding{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: args.PodNamespace,
+			Name:      args.PodName,
+			UID:       args.PodUID,
+		},
+		Target: corev1.ObjectReference{
+			Kind: "Node",
+			Name: args.Node,
+		},
+	}
+	return gpuFilter.callAPI(func() error {
+		return gpuFilter.kubeClient.CoreV1().Pods(args.PodNamespace).Bind(ctx, binding,
+			metav1.CreateOptions{})
+	})
+}
+
+// reservedOn tells if the pod holds a reservation on the node
+func (gpuFilter *GPUFilter) reservedOn(pod *corev1.Pod, nodeName string) bool {
+	return pod.Annotations[util.PredicateNode] == nodeName && !gpuFilter.reservationExpired(pod)
+}
+
+// reserve allocates devices for the pod whose reservation was deferred on the node
+func (gpuFilter *GPUFilter) reserve(ctx context.Context, pod *corev1.Pod, nodeName string) error {
+	node, err := gpuFilter.nodeLister.Get(nodeName)
+	if err != nil {
+		return err
+	}
+	result := gpuFilter.predicate(ctx, pod, []corev1.Node{*node}, false)
+	gpuFilter.auditPredication(pod, result, metrics.ResultPredicated)
+	if result.err != nil {
+		return result.err
+	}
+	if result.node == nil {
+		return fmt.Errorf("pod doesn't fit node %s any more: %s", nodeName,
+			result.failedNodes[nodeName])
+	}
+	gpuFilter.recorder.Eventf(pod, corev1.EventTypeNormal, EventReasonPredicated,
+		"Predicated to node %s with GPU devices %s at binding", nodeName,
+		formatDevices(result.pod))
+	return nil
+}
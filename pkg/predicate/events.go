@@ -0,0 +1,79 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+const (
+	// ComponentName is the source component of events
+	ComponentName = "gpu-admission"
+	// EventReasonPredicated is the reason of event when pod is predicated to a node
+	EventReasonPredicated = "GPUPredicated"
+	// EventReasonFailedPredicate is the reason of event when no node fits the pod
+	EventReasonFailedPredicate = "FailedGPUPredicate"
+)
+
+// short reasons why a node is not chosen
+const (
+	reasonNoGPU        = "no GPU device"
+	reasonListPods     = "failed to get pods on node"
+	reasonPatch        = "update pod annotation failed"
+	reasonMatchedOther = "matched to another node"
+	reasonNotEvaluated = "not evaluated"
+)
+
+// summarizeFailure returns a message like "0/3 nodes are available: 2 insufficient GPU
+// cores, 1 no GPU device."
+func summarizeFailure(numNodes int, failureReasons map[string]string) string {
+	counts := make(map[string]int)
+	for _, reason := range failureReasons {
+		counts[reason]++
+	}
+	var reasons []string
+	for reason, count := range counts {
+		reasons = append(reasons, fmt.Sprintf("%d %s", count, reason))
+	}
+	sort.Strings(reasons)
+	return fmt.Sprintf("0/%d nodes are available: %s.", numNodes, strings.Join(reasons, ", "))
+}
+
+// formatDevices returns the predicated devices of GPU containers, like
+// "container-0: 0, container-1: 1,2"
+func formatDevices(pod *corev1.Pod) string {
+	var devices []string
+	for i, c := range pod.Spec.Containers {
+		indexes, err := util.GetPredicateIdxOfContainer(pod, i)
+		if err != nil {
+			continue
+		}
+		var ids []string
+		for _, index := range indexes {
+			ids = append(ids, strconv.Itoa(index))
+		}
+		devices = append(devices, fmt.Sprintf("%s: %s", c.Name, strings.Join(ids, ",")))
+	}
+	return strings.Join(devices, ", ")
+}
@@ -0,0 +1,310 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/algorithm"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/metrics"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// gangFilter admits pods of a pod group all or nothing. When the first member comes,
+// pending members are placed against the allocation state of nodes until the group
+// has its minimal number of members, and only if enough of them fit, they are
+// predicated at once. The predication annotations hold the reservations, so the other
+// placed members are filtered to their predicated nodes when they come. Members beyond
+// the minimal number are filtered as ordinary pods.
+func (gpuFilter *GPUFilter) gangFilter(ctx context.Context,
+	pod *corev1.Pod, nodes []corev1.Node) ([]corev1.Node, extenderv1.FailedNodesMap, error) {
+	// #lizard forgives
+	var (
+		group         = pod.Annotations[util.PodGroupAnnotation]
+		filteredNodes = make([]corev1.Node, 0)
+		failedNodes   = make(extenderv1.FailedNodesMap)
+	)
+
+	failAll := func(message string) ([]corev1.Node, extenderv1.FailedNodesMap, error) {
+		for _, node := range nodes {
+			failedNodes[node.Name] = message
+		}
+		gpuFilter.auditFailure(pod, failedNodes, nil)
+		gpuFilter.recorder.Event(pod, corev1.EventTypeWarning, EventReasonFailedPredicate,
+			message)
+		return filteredNodes, failedNodes, nil
+	}
+
+	// the pod has been predicated with other members of its group
+	if nodeName, ok := pod.Annotations[util.PredicateNode]; ok && !gpuFilter.reservationExpired(pod) {
+		for _, node := range nodes {
+			if node.Name == nodeName {
+				filteredNodes = append(filteredNodes, node)
+				continue
+			}
+			failedNodes[node.Name] = fmt.Sprintf("pod %s is reserved to node %s by pod group %s",
+				pod.UID, nodeName, group)
+		}
+		if len(filteredNodes) == 0 {
+			// the reservation would never be taken, e.g. the node is cordoned, so the
+			// group is released to be admitted again
+			gpuFilter.releasePodGroup(pod)
+			return failAll(fmt.Sprintf("reserved node %s of pod group %s is not a candidate, "+
+				"reservations of the group are released", nodeName, group))
+		}
+		gpuFilter.auditReservation(pod, nodeName, failedNodes)
+		return filteredNodes, failedNodes, nil
+	}
+
+	members, admitted, err := gpuFilter.listPodGroupMembers(pod)
+	if err != nil {
+		return filteredNodes, failedNodes, err
+	}
+	minMember := podGroupMinMember(pod)
+	if admitted >= minMember {
+		return gpuFilter.podFilter(ctx, pod, nodes)
+	}
+	if len(members)+admitted < minMember {
+		return failAll(fmt.Sprintf("waiting for pod group %s: %d/%d members",
+			group, len(members)+admitted, minMember))
+	}
+
+	result := newPredicateResult()
+	nodeInfoList := gpuFilter.buildNodeInfos(nodes, result)
+	candidates := gpuFilter.auditCandidates(nodeInfoList)
+	placements, err := placeMembers(members, minMember-admitted, nodeInfoList)
+	if err != nil {
+		return failAll(fmt.Sprintf("pod group %s can't be admitted: %v", group, err))
+	}
+
+	// predicate all members, roll back if any of them failed or the request is
+	// cancelled
+	var patched []*corev1.Pod
+	for _, placement := range placements {
+		start := time.Now()
+		err := gpuFilter.patchPodWithAnnotations(ctx, placement.pod,
+			predicateAnnotations(placement.pod))
+		metrics.ObserveStage(metrics.StagePatch, start)
+		if ctx.Err() != nil {
+			// the patch may have been applied even if it's reported failed
+			for _, p := range append(patched, placement.pod) {
+				gpuFilter.removePredicateAnnotations(p)
+			}
+			return filteredNodes, failedNodes, fmt.Errorf(
+				"admission of pod group %s aborted: %v", group, ctx.Err())
+		}
+		if err != nil {
+			for _, p := range patched {
+				gpuFilter.removePredicateAnnotations(p)
+			}
+			return failAll(fmt.Sprintf("pod group %s can't be admitted: %s", group, reasonPatch))
+		}
+		patched = append(patched, placement.pod)
+	}
+
+	gpuFilter.auditPlacements(placements, candidates)
+	for _, placement := range placements {
+		gpuFilter.recordAllocations(placement.pod, placement.node.Name)
+		gpuFilter.recorder.Eventf(placement.pod, corev1.EventTypeNormal, EventReasonPredicated,
+			"Predicated to node %s with GPU devices %s as member of pod group %s",
+			placement.node.Name, formatDevices(placement.pod), group)
+	}
+	chosen := placements[0].node
+	for _, node := range nodes {
+		if node.Name == chosen.Name {
+			filteredNodes = append(filteredNodes, node)
+			continue
+		}
+		failedNodes[node.Name] = fmt.Sprintf(
+			"pod %s has already been matched to another node", pod.UID)
+	}
+	return filteredNodes, failedNodes, nil
+}
+
+type placement struct {
+	pod  *corev1.Pod
+	node *corev1.Node
+}
+
+// placeMembers places num members on a copy of the allocation state of nodes in order.
+// The first member is the pod being filtered, so it must fit, while other members which
+// don't fit are skipped. It fails if fewer than num members fit.
+func placeMembers(members []*corev1.Pod, num int,
+	nodeInfoList []*device.NodeInfo) ([]placement, error) {
+	var (
+		placements []placement
+		sorter     = device.NodeInfoSort(
+			device.ByAllocatableCores,
+			device.ByAllocatableMemory,
+			device.ByID)
+	)
+	for i, member := range members {
+		if len(placements) == num {
+			break
+		}
+		sorter.Sort(nodeInfoList)
+		var (
+			placed  bool
+			reasons = make(map[string]string)
+		)
+		for j, nodeInfo := range nodeInfoList {
+			trial := nodeInfo.Clone()
+			newPod, err := algorithm.NewAllocator(trial).Allocate(member)
+			if err != nil {
+				reasons[nodeInfo.GetName()] = algorithm.Reason(err)
+				continue
+			}
+			nodeInfoList[j] = trial
+			placements = append(placements, placement{pod: newPod, node: trial.GetNode()})
+			placed = true
+			break
+		}
+		if !placed && i == 0 {
+			return nil, fmt.Errorf("member %s doesn't fit, %s", member.Name,
+				summarizeFailure(len(nodeInfoList), reasons))
+		}
+	}
+	if len(placements) < num {
+		return nil, fmt.Errorf("only %d of %d members needed fit", len(placements), num)
+	}
+	return placements, nil
+}
+
+// listPodGroupMembers returns the pending members of the pod group which haven't
+// been predicated, the given pod comes first. The number of members which have been
+// predicated or bound is also returned.
+func (gpuFilter *GPUFilter) listPodGroupMembers(pod *corev1.Pod) ([]*corev1.Pod, int, error) {
+	pods, err := gpuFilter.podLister.Pods(pod.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		group    = pod.Annotations[util.PodGroupAnnotation]
+		members  = []*corev1.Pod{pod}
+		admitted int
+	)
+	for _, p := range pods {
+		if p.UID == pod.UID || p.Annotations[util.PodGroupAnnotation] != group ||
+			p.DeletionTimestamp != nil ||
+			p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		// members without GPU request don't need to be predicated by us
+		// members whose reservations expired are placed again
+		if _, ok := p.Annotations[util.PredicateNode]; (ok && !gpuFilter.reservationExpired(p)) ||
+			p.Spec.NodeName != "" || !util.IsGPURequiredPod(p) {
+			admitted++
+			continue
+		}
+		members = append(members, p)
+	}
+	sort.Slice(members[1:], func(i, j int) bool {
+		return members[i+1].Name < members[j+1].Name
+	})
+	return members, admitted, nil
+}
+
+// releasePodGroup removes the reservations of the pod and other members of its pod
+// group which are predicated but not bound
+func (gpuFilter *GPUFilter) releasePodGroup(pod *corev1.Pod) {
+	group := pod.Annotations[util.PodGroupAnnotation]
+	gpuFilter.removePredicateAnnotations(pod)
+	pods, err := gpuFilter.podLister.Pods(pod.Namespace).List(labels.Everything())
+	if err != nil {
+		klog.Errorf("failed to list members of pod group %s: %v", group, err)
+		return
+	}
+	for _, p := range pods {
+		if p.UID == pod.UID || p.Annotations[util.PodGroupAnnotation] != group ||
+			p.Spec.NodeName != "" {
+			continue
+		}
+		if _, ok := p.Annotations[util.PredicateNode]; ok {
+			gpuFilter.removePredicateAnnotations(p)
+		}
+	}
+}
+
+// podGroupMinMember returns the minimal number of members of the pod group
+func podGroupMinMember(pod *corev1.Pod) int {
+	v, ok := pod.Annotations[util.PodGroupMinMemberAnnotation]
+	if !ok {
+		return 1
+	}
+	minMember, err := strconv.Atoi(v)
+	if err != nil {
+		klog.Infof("invalid annotation %s=%s of pod %s", util.PodGroupMinMemberAnnotation,
+			v, pod.Name)
+		return 1
+	}
+	return minMember
+}
+
+// removePredicateAnnotations releases the reservation of a predicated pod, it's not
+// bound to the request as it runs after the request is cancelled. It doesn't go
+// through the breaker, as the reservation would be leaked if it were rejected, but
+// retries until waitTimeout is exceeded.
+func (gpuFilter *GPUFilter) removePredicateAnnotations(pod *corev1.Pod) {
+	annotations := make(map[string]interface{})
+	for k := range predicateAnnotations(pod) {
+		// a null value removes the annotation
+		annotations[k] = nil
+	}
+	payload := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	}
+	payloadBytes, _ := json.Marshal(payload)
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	var lastErr error
+	err := wait.PollImmediateUntil(time.Second, func() (bool, error) {
+		_, lastErr = gpuFilter.kubeClient.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name,
+			k8stypes.StrategicMergePatchType, payloadBytes, metav1.PatchOptions{})
+		switch {
+		case lastErr == nil || apierrors.IsNotFound(lastErr):
+			// the reservation is gone with the pod
+			return true, nil
+		case util.ShouldRetry(lastErr) || isAPIFailure(lastErr):
+			return false, nil
+		}
+		return false, lastErr
+	}, ctx.Done())
+	if err == wait.ErrWaitTimeout && lastErr != nil {
+		err = lastErr
+	}
+	if err != nil {
+		klog.Errorf("failed to remove predication annotations of pod %s: %v", pod.UID, err)
+	}
+}
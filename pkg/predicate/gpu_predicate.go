@@ -19,23 +19,37 @@ package predicate
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	k8stypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
 	extenderv1 "k8s.io/kube-scheduler/extender/v1"
 
 	"tkestack.io/gpu-admission/pkg/algorithm"
+	"tkestack.io/gpu-admission/pkg/audit"
+	"tkestack.io/gpu-admission/pkg/breaker"
+	"tkestack.io/gpu-admission/pkg/client/clientset/versioned"
+	gpulisters "tkestack.io/gpu-admission/pkg/client/listers/gpu/v1alpha1"
 	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/metrics"
 	"tkestack.io/gpu-admission/pkg/util"
 )
 
@@ -43,15 +57,118 @@ type GPUFilter struct {
 	kubeClient kubernetes.Interface
 	nodeLister listerv1.NodeLister
 	podLister  listerv1.PodLister
+	recorder   record.EventRecorder
+	// options to build NodeInfo of each node
+	nodeInfoOptions []device.Option
+	// reservationTTL is how long predicated but unbound pods hold their devices
+	reservationTTL time.Duration
+	// gpuClient reads and writes GPU objects, allocationRecord tells if allocations
+	// are recorded in GPUAllocation objects, gpuNodeInventory tells if devices of
+	// nodes are read from GPUNode objects
+	gpuClient        versioned.Interface
+	allocationRecord bool
+	gpuNodeInventory bool
+	allocationLister gpulisters.GPUAllocationLister
+	gpuNodeLister    gpulisters.GPUNodeLister
+	// apiBreaker stops calling the API server while it's degraded, failurePolicy
+	// tells how pods are filtered meanwhile
+	apiBreaker    *breaker.Breaker
+	breakerConfig breaker.Config
+	failurePolicy FailurePolicy
+	// auditLogger writes each filter decision if it's set
+	auditLogger *audit.Logger
+	// filterTimeout is the budget of each filter request, 0 means no limit other than
+	// the request itself
+	filterTimeout time.Duration
+	// parallelism is the number of workers building and evaluating nodes
+	parallelism int
+	// percentageOfNodesToScore is the percentage of nodes to find feasible before the
+	// search stops, nextStartNodeIndex is where the next search starts from
+	percentageOfNodesToScore int
+	nextStartNodeIndex       int64
+	// cacheSyncs tell if the informers have synced, synced is set to 1 after all of
+	// them have synced
+	cacheSyncs []cache.InformerSynced
+	synced     int32
+	// stopCh stops the informers when closed
+	stopCh chan struct{}
+	// eventWatcher sends recorded events to the API server. The broadcaster is not
+	// shut down, as events are sent to it asynchronously and sending them after it
+	// has been shut down panics.
+	eventWatcher watch.Interface
 }
 
+// ErrNotReady is returned before the caches have synced, the scheduler should
+// retry later rather than make decisions on an incomplete view of the cluster
+var ErrNotReady = errors.New("gpu-admission is not ready: caches are not synced, retry later")
+
+// Option configures GPUFilter
+type Option func(*GPUFilter)
+
+// WithNodeInfoOptions sets the options used to build NodeInfo of each node
+func WithNodeInfoOptions(opts ...device.Option) Option {
+	return func(gpuFilter *GPUFilter) {
+		gpuFilter.nodeInfoOptions = append(gpuFilter.nodeInfoOptions, opts...)
+	}
+}
+
+// WithReservationTTL sets how long pods predicated but not bound hold their devices,
+// pods with expired reservations can be predicated again
+func WithReservationTTL(ttl time.Duration) Option {
+	return func(gpuFilter *GPUFilter) {
+		gpuFilter.reservationTTL = ttl
+		gpuFilter.nodeInfoOptions = append(gpuFilter.nodeInfoOptions,
+			device.WithReservationTTL(ttl))
+	}
+}
+
+// WithFilterTimeout sets the budget of each filter request, work is stopped and the
+// reservation is released once it's exceeded
+func WithFilterTimeout(timeout time.Duration) Option {
+	return func(gpuFilter *GPUFilter) {
+		gpuFilter.filterTimeout = timeout
+	}
+}
+
+// WithParallelism sets the number of workers building and evaluating nodes, values
+// less than 1 are taken as 1
+func WithParallelism(parallelism int) Option {
+	return func(gpuFilter *GPUFilter) {
+		if parallelism < 1 {
+			klog.Infof("parallelism %d less than 1, use 1 instead", parallelism)
+			parallelism = 1
+		}
+		gpuFilter.parallelism = parallelism
+	}
+}
+
+// WithPercentageOfNodesToScore sets the percentage of nodes to find feasible before
+// the search stops, 0 means an adaptive percentage depending on the cluster size
+func WithPercentageOfNodesToScore(percentage int) Option {
+	return func(gpuFilter *GPUFilter) {
+		gpuFilter.percentageOfNodesToScore = percentage
+	}
+}
+
+// DefaultParallelism is the default number of workers building and evaluating nodes
+const DefaultParallelism = 16
+
+const (
+	// minFeasibleNodesToFind is the minimal number of feasible nodes to find before
+	// the search stops
+	minFeasibleNodesToFind = 100
+	// minFeasibleNodesPercentageToFind is the minimal adaptive percentage of nodes to
+	// find feasible
+	minFeasibleNodesPercentageToFind = 5
+)
+
 const (
 	NAME          = "GPUPredicate"
 	PodPhaseField = "status.phase"
 	waitTimeout   = 10 * time.Second
 )
 
-func NewGPUFilter(client kubernetes.Interface) (*GPUFilter, error) {
+func NewGPUFilter(client kubernetes.Interface, opts ...Option) (*GPUFilter, error) {
 	nodeInformerFactory := kubeinformers.NewSharedInformerFactory(client, time.Second*30)
 
 	podListOptions := func(options *metav1.ListOptions) {
@@ -61,26 +178,81 @@ func NewGPUFilter(client kubernetes.Interface) (*GPUFilter, error) {
 		time.Second*30, kubeinformers.WithNamespace(metav1.NamespaceAll),
 		kubeinformers.WithTweakListOptions(podListOptions))
 
+	eventBroadcaster := record.NewBroadcaster()
+	eventWatcher := eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: client.CoreV1().Events(metav1.NamespaceAll),
+	})
+
+	nodeInformer := nodeInformerFactory.Core().V1().Nodes()
+	podInformer := podInformerFactory.Core().V1().Pods()
 	gpuFilter := &GPUFilter{
 		kubeClient: client,
-		nodeLister: nodeInformerFactory.Core().V1().Nodes().Lister(),
-		podLister:  podInformerFactory.Core().V1().Pods().Lister(),
+		nodeLister: nodeInformer.Lister(),
+		podLister:  podInformer.Lister(),
+		recorder: eventBroadcaster.NewRecorder(scheme.Scheme,
+			corev1.EventSource{Component: ComponentName}),
+		stopCh:                   make(chan struct{}),
+		eventWatcher:             eventWatcher,
+		breakerConfig:            defaultBreakerConfig,
+		failurePolicy:            FailClosed,
+		parallelism:              DefaultParallelism,
+		percentageOfNodesToScore: 100,
+		cacheSyncs: []cache.InformerSynced{
+			nodeInformer.Informer().HasSynced,
+			podInformer.Informer().HasSynced,
+		},
 	}
+	for _, opt := range opts {
+		opt(gpuFilter)
+	}
+	gpuFilter.apiBreaker = newAPIBreaker(gpuFilter.breakerConfig)
 
-	go nodeInformerFactory.Start(nil)
-	go podInformerFactory.Start(nil)
+	go nodeInformerFactory.Start(gpuFilter.stopCh)
+	go podInformerFactory.Start(gpuFilter.stopCh)
+	gpuFilter.startGPUInformers()
+	go gpuFilter.waitForCacheSync()
 
 	return gpuFilter, nil
 }
 
+// waitForCacheSync marks the filter ready after all informers have synced
+func (gpuFilter *GPUFilter) waitForCacheSync() {
+	if !cache.WaitForCacheSync(gpuFilter.stopCh, gpuFilter.cacheSyncs...) {
+		return
+	}
+	atomic.StoreInt32(&gpuFilter.synced, 1)
+	klog.Infof("caches of %s synced", NAME)
+}
+
+// Stop stops the informers and recording events, it should be called after serving
+// requests has been stopped
+func (gpuFilter *GPUFilter) Stop() {
+	close(gpuFilter.stopCh)
+	gpuFilter.eventWatcher.Stop()
+	klog.Infof("%s stopped", NAME)
+}
+
+// Ready returns ErrNotReady until the caches have synced
+func (gpuFilter *GPUFilter) Ready() error {
+	if atomic.LoadInt32(&gpuFilter.synced) == 0 {
+		return ErrNotReady
+	}
+	if gpuFilter.failurePolicy == FailClosed {
+		return gpuFilter.apiBreaker.Ready()
+	}
+	return nil
+}
+
 func (gpuFilter *GPUFilter) Name() string {
 	return NAME
 }
 
-type filterFunc func(*corev1.Pod, []corev1.Node) ([]corev1.Node, extenderv1.FailedNodesMap,
-	error)
+type filterFunc func(context.Context, *corev1.Pod, []corev1.Node) ([]corev1.Node,
+	extenderv1.FailedNodesMap, error)
 
-func (gpuFilter *GPUFilter) Filter(
+// Filter stops working once ctx is done or the filter timeout is exceeded, and the
+// reservation which has been made is released
+func (gpuFilter *GPUFilter) Filter(ctx context.Context,
 	args extenderv1.ExtenderArgs,
 ) *extenderv1.ExtenderFilterResult {
 	if !util.IsGPURequiredPod(args.Pod) {
@@ -91,14 +263,48 @@ func (gpuFilter *GPUFilter) Filter(
 		}
 	}
 
+	// while the API server is degraded, pods are either unschedulable or placed
+	// without reservations
 	filters := []filterFunc{
 		gpuFilter.deviceFilter,
 	}
+	result := metrics.ResultPredicated
+	if gpuFilter.degraded() {
+		if gpuFilter.failurePolicy == FailClosed {
+			metrics.PredicatedPods.WithLabelValues(podMode(args.Pod), metrics.ResultUnfit).Inc()
+			filterResult := failClosed(args.Nodes.Items)
+			gpuFilter.auditFailure(args.Pod, filterResult.FailedNodes, nil)
+			return filterResult
+		}
+		filters = []filterFunc{
+			gpuFilter.deferredFilter,
+		}
+		result = metrics.ResultDeferred
+	}
+
+	if err := gpuFilter.Ready(); err != nil {
+		metrics.PredicatedPods.WithLabelValues(podMode(args.Pod), metrics.ResultError).Inc()
+		return &extenderv1.ExtenderFilterResult{
+			Error: err.Error(),
+		}
+	}
+
+	start := time.Now()
+	defer func() {
+		metrics.FilterLatency.Observe(time.Since(start).Seconds())
+	}()
+	if gpuFilter.filterTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, gpuFilter.filterTimeout)
+		defer cancel()
+	}
+
 	filteredNodes := args.Nodes.Items
 	failedNodesMap := make(extenderv1.FailedNodesMap)
 	for _, filter := range filters {
-		passedNodes, failedNodes, err := filter(args.Pod, filteredNodes)
+		passedNodes, failedNodes, err := filter(ctx, args.Pod, filteredNodes)
 		if err != nil {
+			metrics.PredicatedPods.WithLabelValues(podMode(args.Pod), metrics.ResultError).Inc()
 			return &extenderv1.ExtenderFilterResult{
 				Error: err.Error(),
 			}
@@ -109,6 +315,11 @@ func (gpuFilter *GPUFilter) Filter(
 		}
 	}
 
+	if len(filteredNodes) == 0 {
+		result = metrics.ResultUnfit
+	}
+	metrics.PredicatedPods.WithLabelValues(podMode(args.Pod), result).Inc()
+
 	return &extenderv1.ExtenderFilterResult{
 		Nodes: &corev1.NodeList{
 			Items: filteredNodes,
@@ -118,84 +329,425 @@ func (gpuFilter *GPUFilter) Filter(
 	}
 }
 
-//deviceFilter will choose one and only one node fullfil the request,
-//so it should always be the last filter of gpuFilter
-func (gpuFilter *GPUFilter) deviceFilter(
+// deviceFilter will choose one and only one node fullfil the request,
+// so it should always be the last filter of gpuFilter
+func (gpuFilter *GPUFilter) deviceFilter(ctx context.Context,
 	pod *corev1.Pod, nodes []corev1.Node) ([]corev1.Node, extenderv1.FailedNodesMap, error) {
-	// #lizard forgives
-	var (
-		filteredNodes  = make([]corev1.Node, 0)
-		failedNodesMap = make(extenderv1.FailedNodesMap)
-		nodeInfoList   []*device.NodeInfo
-		success        bool
-		sorter         = device.NodeInfoSort(
-			device.ByAllocatableCores,
-			device.ByAllocatableMemory,
-			device.ByID)
-	)
-	for k := range pod.Annotations {
-		if strings.Contains(k, util.GPUAssigned) ||
-			strings.Contains(k, util.PredicateTimeAnnotation) ||
-			strings.Contains(k, util.PredicateGPUIndexPrefix) {
-			return filteredNodes, failedNodesMap, fmt.Errorf("pod %s had been predicated!", pod.Name)
+	if _, ok := pod.Annotations[util.PodGroupAnnotation]; ok {
+		return gpuFilter.gangFilter(ctx, pod, nodes)
+	}
+	return gpuFilter.podFilter(ctx, pod, nodes)
+}
+
+// podFilter predicates a pod on its own
+func (gpuFilter *GPUFilter) podFilter(ctx context.Context,
+	pod *corev1.Pod, nodes []corev1.Node) ([]corev1.Node, extenderv1.FailedNodesMap, error) {
+	var filteredNodes = make([]corev1.Node, 0)
+	if gpuFilter.reservationExpired(pod) {
+		klog.Infof("reservation of pod %s/%s expired, predicate it again", pod.Namespace,
+			pod.Name)
+	} else {
+		for k := range pod.Annotations {
+			if strings.Contains(k, util.GPUAssigned) ||
+				strings.Contains(k, util.PredicateTimeAnnotation) ||
+				strings.Contains(k, util.PredicateGPUIndexPrefix) {
+				err := fmt.Errorf("pod %s had been predicated!", pod.Name)
+				gpuFilter.auditFailure(pod, nil, err)
+				return filteredNodes, make(extenderv1.FailedNodesMap), err
+			}
 		}
 	}
 
+	result := gpuFilter.predicate(ctx, pod, nodes, false)
+	gpuFilter.auditPredication(pod, result, metrics.ResultPredicated)
+	if result.err != nil {
+		return filteredNodes, make(extenderv1.FailedNodesMap), result.err
+	}
+	if result.node != nil {
+		filteredNodes = append(filteredNodes, *result.node)
+		gpuFilter.recorder.Eventf(pod, corev1.EventTypeNormal, EventReasonPredicated,
+			"Predicated to node %s with GPU devices %s", result.node.Name,
+			formatDevices(result.pod))
+	} else {
+		gpuFilter.recorder.Event(pod, corev1.EventTypeWarning, EventReasonFailedPredicate,
+			summarizeFailure(len(nodes), result.failureReasons))
+	}
+
+	return filteredNodes, result.failedNodes, nil
+}
+
+type predicateResult struct {
+	// node is the chosen node, it's nil if no node fits the pod
+	node *corev1.Node
+	// pod is the pod with predication annotations
+	pod         *corev1.Pod
+	failedNodes extenderv1.FailedNodesMap
+	// failureReasons maps failed node to the short reason why it's not chosen
+	failureReasons map[string]string
+	// err is set if the predication is aborted, e.g. the request is cancelled
+	err error
+	// candidates are the state of evaluated nodes if decisions are audited, order is
+	// the feasible nodes in the order they are preferred
+	candidates []audit.Candidate
+	order      []string
+}
+
+func newPredicateResult() *predicateResult {
+	return &predicateResult{
+		failedNodes:    make(extenderv1.FailedNodesMap),
+		failureReasons: make(map[string]string),
+	}
+}
+
+func (r *predicateResult) fail(nodeName, reason, message string) {
+	r.failureReasons[nodeName] = reason
+	r.failedNodes[nodeName] = message
+}
+
+// buildNodeInfos builds the allocation state of GPU nodes, the nodes which can't be
+// built are recorded in result as failed ones
+func (gpuFilter *GPUFilter) buildNodeInfos(nodes []corev1.Node,
+	result *predicateResult) []*device.NodeInfo {
+	candidates, podsByNode := gpuFilter.candidateNodes(nodes, result)
+
+	// nodes are built in parallel, each into its own slot to keep the order
+	nodeInfoList := make([]*device.NodeInfo, len(candidates))
+	workqueue.ParallelizeUntil(context.Background(), gpuFilter.parallelism, len(candidates),
+		func(i int) {
+			start := time.Now()
+			nodeInfoList[i] = gpuFilter.newNodeInfo(candidates[i], podsByNode[candidates[i].Name])
+			metrics.ObserveStage(metrics.StageBuildNodeInfo, start)
+		})
+	return nodeInfoList
+}
+
+// candidateNodes returns the GPU nodes and the pods on each of them, the other nodes
+// are recorded in result as failed ones
+func (gpuFilter *GPUFilter) candidateNodes(nodes []corev1.Node,
+	result *predicateResult) ([]*corev1.Node, map[string][]*corev1.Pod) {
+	start := time.Now()
+	podsByNode, err := gpuFilter.listPodsByNode()
+	metrics.ObserveStage(metrics.StageListPods, start)
+
+	var candidates []*corev1.Node
 	for i := range nodes {
 		node := &nodes[i]
-		if !util.IsGPUEnabledNode(node) {
-			failedNodesMap[node.Name] = "no GPU device"
+		switch {
+		case !util.IsGPUEnabledNode(node):
+			result.fail(node.Name, reasonNoGPU, reasonNoGPU)
+		case err != nil:
+			result.fail(node.Name, reasonListPods, reasonListPods)
+		default:
+			candidates = append(candidates, node)
+		}
+	}
+	return candidates, podsByNode
+}
+
+// numFeasibleNodesToFind returns the number of feasible nodes to find before the
+// search stops, it's computed the same way as kube-scheduler does
+func (gpuFilter *GPUFilter) numFeasibleNodesToFind(numAllNodes int) int {
+	percentage := gpuFilter.percentageOfNodesToScore
+	if numAllNodes < minFeasibleNodesToFind || percentage >= 100 {
+		return numAllNodes
+	}
+	if percentage <= 0 {
+		// adaptive percentage, the larger the cluster, the smaller the percentage
+		percentage = 50 - numAllNodes/125
+		if percentage < minFeasibleNodesPercentageToFind {
+			percentage = minFeasibleNodesPercentageToFind
+		}
+	}
+	num := numAllNodes * percentage / 100
+	if num < minFeasibleNodesToFind {
+		return minFeasibleNodesToFind
+	}
+	return num
+}
+
+// podMode returns exclusive if any container of pod needs whole devices, otherwise
+// shared
+func podMode(pod *corev1.Pod) string {
+	for i := range pod.Spec.Containers {
+		if util.GetGPUCoresOfContainer(&pod.Spec.Containers[i]) >= util.HundredCore {
+			return metrics.ModeExclusive
+		}
+	}
+	return metrics.ModeShared
+}
+
+// reservationExpired tells if the pod was predicated but not bound in time
+func (gpuFilter *GPUFilter) reservationExpired(pod *corev1.Pod) bool {
+	return util.IsReservationExpired(pod, gpuFilter.reservationTTL, time.Now())
+}
+
+// predicateAnnotations returns the annotations written by predication
+func predicateAnnotations(pod *corev1.Pod) map[string]string {
+	annotationMap := make(map[string]string)
+	for k, v := range pod.Annotations {
+		if strings.Contains(k, util.GPUAssigned) ||
+			strings.Contains(k, util.PredicateTimeAnnotation) ||
+			strings.Contains(k, util.PredicateGPUIndexPrefix) ||
+			strings.Contains(k, util.PredicateNode) {
+			annotationMap[k] = v
+		}
+	}
+	return annotationMap
+}
+
+// predicate builds the allocation state of given nodes and allocates devices for pod
+// on the most suitable one. The chosen node and the pod with predication annotations
+// are returned. If dryRun is true, the annotations will not be patched to the pod.
+// The predication is aborted once ctx is done, and the reservation is released if the
+// pod has been patched.
+func (gpuFilter *GPUFilter) predicate(ctx context.Context, pod *corev1.Pod,
+	nodes []corev1.Node, dryRun bool) *predicateResult {
+	// #lizard forgives
+	var (
+		result                 = newPredicateResult()
+		candidates, podsByNode = gpuFilter.candidateNodes(nodes, result)
+		numToFind              = gpuFilter.numFeasibleNodesToFind(len(candidates))
+		offset                 int
+	)
+	// if the search stops early, it starts from where the last one stopped, so that
+	// nodes at the head of the list are not always preferred
+	if numToFind < len(candidates) {
+		offset = int(atomic.LoadInt64(&gpuFilter.nextStartNodeIndex) % int64(len(candidates)))
+	}
+
+	// nodes are built and the pod is allocated on them in parallel, as allocation on a
+	// node doesn't affect the others. Each node is evaluated into its own slot.
+	var (
+		nodeInfos       = make([]*device.NodeInfo, len(candidates))
+		newPods         = make([]*corev1.Pod, len(candidates))
+		errs            = make([]error, len(candidates))
+		found           int32
+		evalCtx, cancel = context.WithCancel(ctx)
+	)
+	defer cancel()
+	workqueue.ParallelizeUntil(evalCtx, gpuFilter.parallelism, len(candidates), func(i int) {
+		node := candidates[(offset+i)%len(candidates)]
+		start := time.Now()
+		nodeInfos[i] = gpuFilter.newNodeInfo(node, podsByNode[node.Name])
+		metrics.ObserveStage(metrics.StageBuildNodeInfo, start)
+
+		// the pod is allocated on a copy, so the original state is kept for sorting
+		start = time.Now()
+		newPods[i], errs[i] = algorithm.NewAllocator(nodeInfos[i].Clone()).Allocate(pod)
+		metrics.ObserveStage(metrics.StageAllocate, start)
+		if errs[i] == nil && int(atomic.AddInt32(&found, 1)) >= numToFind {
+			cancel()
+		}
+	})
+
+	var (
+		feasible  []*device.NodeInfo
+		podOfNode = make(map[string]*corev1.Pod)
+		evaluated int
+	)
+	for i, nodeInfo := range nodeInfos {
+		if nodeInfo == nil {
 			continue
 		}
-		pods, err := gpuFilter.ListPodsOnNode(node)
-		if err != nil {
-			failedNodesMap[node.Name] = "failed to get pods on node"
+		evaluated++
+		if gpuFilter.auditLogger != nil {
+			result.candidates = append(result.candidates, audit.NewCandidate(nodeInfo))
+		}
+		if errs[i] != nil {
+			result.fail(nodeInfo.GetName(), algorithm.Reason(errs[i]), errs[i].Error())
 			continue
 		}
-		nodeInfo := device.NewNodeInfo(node, pods)
-		nodeInfoList = append(nodeInfoList, nodeInfo)
+		feasible = append(feasible, nodeInfo)
+		podOfNode[nodeInfo.GetName()] = newPods[i]
+	}
+	if !dryRun && numToFind < len(candidates) {
+		atomic.AddInt64(&gpuFilter.nextStartNodeIndex, int64(evaluated))
+	}
+	if err := ctx.Err(); err != nil {
+		result.err = fmt.Errorf("predication of pod %s aborted: %v", pod.Name, err)
+		return result
 	}
-	sorter.Sort(nodeInfoList)
 
-	for _, nodeInfo := range nodeInfoList {
+	// the first feasible node in order is chosen
+	sorter := device.NodeInfoSort(
+		device.ByAllocatableCores,
+		device.ByAllocatableMemory,
+		device.ByID)
+	sorter.Sort(feasible)
+	for _, nodeInfo := range feasible {
+		result.order = append(result.order, nodeInfo.GetName())
+	}
+	for _, nodeInfo := range feasible {
 		node := nodeInfo.GetNode()
-		if success {
-			failedNodesMap[node.Name] = fmt.Sprintf(
-				"pod %s has already been matched to another node", pod.UID)
+		if result.node != nil {
+			result.fail(node.Name, reasonMatchedOther, fmt.Sprintf(
+				"pod %s has already been matched to another node", pod.UID))
 			continue
 		}
 
-		alloc := algorithm.NewAllocator(nodeInfo)
-		newPod, err := alloc.Allocate(pod)
-		if err != nil {
-			failedNodesMap[node.Name] = fmt.Sprintf(
-				"pod %s does not match with this node", pod.UID)
+		newPod := podOfNode[node.Name]
+		if !dryRun {
+			start := time.Now()
+			err := gpuFilter.patchPodWithAnnotations(ctx, newPod, predicateAnnotations(newPod))
+			metrics.ObserveStage(metrics.StagePatch, start)
+			if ctx.Err() != nil {
+				// the scheduler has given up, the reservation would never be bound. The
+				// patch may have been applied even if it's reported failed.
+				gpuFilter.removePredicateAnnotations(newPod)
+				result.err = fmt.Errorf("predication of pod %s aborted: %v", pod.Name,
+					ctx.Err())
+				return result
+			}
+			if err != nil {
+				result.fail(node.Name, reasonPatch, reasonPatch)
+				continue
+			}
+			gpuFilter.recordAllocations(newPod, node.Name)
+		}
+		result.node = node
+		result.pod = newPod
+	}
+
+	// the nodes not evaluated because enough feasible ones have been found
+	for i, nodeInfo := range nodeInfos {
+		if nodeInfo != nil {
 			continue
+		}
+		node := candidates[(offset+i)%len(candidates)]
+		if result.node != nil {
+			result.fail(node.Name, reasonMatchedOther, fmt.Sprintf(
+				"pod %s has already been matched to another node", pod.UID))
 		} else {
-			annotationMap := make(map[string]string)
-			for k, v := range newPod.Annotations {
-				if strings.Contains(k, util.GPUAssigned) ||
-					strings.Contains(k, util.PredicateTimeAnnotation) ||
-					strings.Contains(k, util.PredicateGPUIndexPrefix) ||
-					strings.Contains(k, util.PredicateNode) {
-					annotationMap[k] = v
-				}
-			}
-			err := gpuFilter.patchPodWithAnnotations(newPod, annotationMap)
+			result.fail(node.Name, reasonNotEvaluated, reasonNotEvaluated)
+		}
+	}
+
+	return result
+}
+
+// Simulate runs the same predication as Filter against the live cache, but the pod
+// will not be updated, so it tells whether and where the pod would fit
+func (gpuFilter *GPUFilter) Simulate(ctx context.Context, args SimulateArgs) *SimulateResult {
+	if args.Pod == nil {
+		return &SimulateResult{Error: "pod is required"}
+	}
+	if err := gpuFilter.Ready(); err != nil {
+		return &SimulateResult{Error: err.Error()}
+	}
+	if !util.IsGPURequiredPod(args.Pod) {
+		return &SimulateResult{
+			Error: fmt.Sprintf("pod %s does not request GPU resource", args.Pod.Name),
+		}
+	}
+
+	var (
+		nodes       []corev1.Node
+		failedNodes = make(extenderv1.FailedNodesMap)
+	)
+	if len(args.NodeNames) == 0 {
+		nodeList, err := gpuFilter.nodeLister.List(labels.Everything())
+		if err != nil {
+			return &SimulateResult{Error: err.Error()}
+		}
+		for _, node := range nodeList {
+			nodes = append(nodes, *node)
+		}
+	} else {
+		for _, name := range args.NodeNames {
+			node, err := gpuFilter.nodeLister.Get(name)
 			if err != nil {
-				failedNodesMap[node.Name] = "update pod annotation failed"
+				failedNodes[name] = fmt.Sprintf("failed to get node: %v", err)
 				continue
 			}
-			filteredNodes = append(filteredNodes, *node)
-			success = true
+			nodes = append(nodes, *node)
+		}
+	}
+
+	predicated := gpuFilter.predicate(ctx, args.Pod, nodes, true)
+	if predicated.err != nil {
+		return &SimulateResult{Error: predicated.err.Error()}
+	}
+	for name, reason := range predicated.failedNodes {
+		failedNodes[name] = reason
+	}
+	result := &SimulateResult{
+		FailedNodes: failedNodes,
+	}
+	if predicated.node == nil {
+		return result
+	}
+
+	node, newPod := predicated.node, predicated.pod
+	result.Node = node.Name
+	result.Devices = make(map[string][]int)
+	for i, c := range newPod.Spec.Containers {
+		if !util.IsGPURequiredContainer(&c) {
+			continue
+		}
+		indexes, err := util.GetPredicateIdxOfContainer(newPod, i)
+		if err != nil {
+			return &SimulateResult{Error: err.Error()}
+		}
+		result.Devices[c.Name] = indexes
+	}
+	return result
+}
+
+// ListNodeInfos returns the allocation state of GPU nodes which match the selector
+func (gpuFilter *GPUFilter) ListNodeInfos(selector labels.Selector) ([]*device.NodeInfo, error) {
+	nodes, err := gpuFilter.nodeLister.List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	// pods are listed once and grouped by node, instead of once per node
+	podsByNode, err := gpuFilter.listPodsByNode()
+	if err != nil {
+		return nil, err
+	}
+	var nodeInfoList []*device.NodeInfo
+	for _, node := range nodes {
+		if !util.IsGPUEnabledNode(node) {
+			continue
 		}
+		nodeInfoList = append(nodeInfoList, gpuFilter.newNodeInfo(node, podsByNode[node.Name]))
+	}
+	return nodeInfoList, nil
+}
+
+// GetNodeInfo returns the allocation state of given GPU node
+func (gpuFilter *GPUFilter) GetNodeInfo(name string) (*device.NodeInfo, error) {
+	node, err := gpuFilter.nodeLister.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if !util.IsGPUEnabledNode(node) {
+		return nil, apierrors.NewNotFound(corev1.Resource("nodes"), name)
+	}
+	podsByNode, err := gpuFilter.listPodsByNode()
+	if err != nil {
+		return nil, err
+	}
+	return gpuFilter.newNodeInfo(node, podsByNode[name]), nil
+}
+
+// listPodsByNode returns the pods running on or predicated to each node
+func (gpuFilter *GPUFilter) listPodsByNode() (map[string][]*corev1.Pod, error) {
+	pods, err := gpuFilter.podLister.Pods(corev1.NamespaceAll).List(labels.Everything())
+	if err != nil {
+		return nil, err
 	}
 
-	return filteredNodes, failedNodesMap, nil
+	ret := make(map[string][]*corev1.Pod)
+	for _, pod := range pods {
+		if nodeName := util.GetNodeNameOfPod(pod); nodeName != "" {
+			ret[nodeName] = append(ret[nodeName], pod)
+		}
+	}
+	return ret, nil
 }
 
 func (gpuFilter *GPUFilter) ListPodsOnNode(node *corev1.Node) ([]*corev1.Pod, error) {
-	// #lizard forgives
 	pods, err := gpuFilter.podLister.Pods(corev1.NamespaceAll).List(labels.Everything())
 	if err != nil {
 		return nil, err
@@ -204,15 +756,7 @@ func (gpuFilter *GPUFilter) ListPodsOnNode(node *corev1.Node) ([]*corev1.Pod, er
 	var ret []*corev1.Pod
 	for _, pod := range pods {
 		klog.V(9).Infof("List pod %s", pod.Name)
-		var predicateNode string
-		if pod.Spec.NodeName == "" && pod.Annotations != nil {
-			if v, ok := pod.Annotations[util.PredicateNode]; ok {
-				predicateNode = v
-			}
-		}
-		if (pod.Spec.NodeName == node.Name || predicateNode == node.Name) &&
-			pod.Status.Phase != corev1.PodSucceeded &&
-			pod.Status.Phase != corev1.PodFailed {
+		if util.IsPodOnNode(pod, node.Name) {
 			ret = append(ret, pod)
 			klog.V(9).Infof("get pod %s on node %s", pod.UID, node.Name)
 		}
@@ -220,7 +764,10 @@ func (gpuFilter *GPUFilter) ListPodsOnNode(node *corev1.Node) ([]*corev1.Pod, er
 	return ret, nil
 }
 
-func (gpuFilter *GPUFilter) patchPodWithAnnotations(
+// patchPodWithAnnotations retries patching until it succeeds, waitTimeout is exceeded
+// or ctx is done. The retries are one call through the breaker, so only their final
+// result is counted.
+func (gpuFilter *GPUFilter) patchPodWithAnnotations(ctx context.Context,
 	pod *corev1.Pod, annotationMap map[string]string) error {
 	// update annotations by patching to the pod
 	type patchMetadata struct {
@@ -236,17 +783,32 @@ func (gpuFilter *GPUFilter) patchPodWithAnnotations(
 	}
 
 	payloadBytes, _ := json.Marshal(payload)
-	err := wait.PollImmediate(time.Second, waitTimeout, func() (bool, error) {
-		_, err := gpuFilter.kubeClient.CoreV1().Pods(pod.Namespace).
-			Patch(context.Background(), pod.Name, k8stypes.StrategicMergePatchType, payloadBytes, metav1.PatchOptions{})
-		if err == nil {
-			return true, nil
-		}
-		if util.ShouldRetry(err) {
-			return false, nil
+	pollCtx, cancel := context.WithTimeout(ctx, waitTimeout)
+	defer cancel()
+	err := gpuFilter.callAPI(func() error {
+		var lastErr error
+		err := wait.PollImmediateUntil(time.Second, func() (bool, error) {
+			_, lastErr = gpuFilter.kubeClient.CoreV1().Pods(pod.Namespace).Patch(pollCtx,
+				pod.Name, k8stypes.StrategicMergePatchType, payloadBytes, metav1.PatchOptions{})
+			if lastErr == nil {
+				return true, nil
+			}
+			if util.ShouldRetry(lastErr) {
+				metrics.PatchRetries.Inc()
+				return false, nil
+			}
+			return false, lastErr
+		}, pollCtx.Done())
+		if err == wait.ErrWaitTimeout {
+			// the caller gave up, or the API server kept failing until waitTimeout
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if lastErr != nil {
+				return lastErr
+			}
 		}
-
-		return false, err
+		return err
 	})
 	if err != nil {
 		msg := fmt.Sprintf("failed to add annotation %v to pod %s due to %s",
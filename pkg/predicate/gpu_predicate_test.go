@@ -18,18 +18,38 @@ package predicate
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"tkestack.io/gpu-admission/pkg/algorithm"
+	"tkestack.io/gpu-admission/pkg/audit"
+	"tkestack.io/gpu-admission/pkg/breaker"
+	gpufake "tkestack.io/gpu-admission/pkg/client/clientset/versioned/fake"
+	"tkestack.io/gpu-admission/pkg/metrics"
 	"tkestack.io/gpu-admission/pkg/util"
+	utiltesting "tkestack.io/gpu-admission/pkg/util/testing"
 
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/component-base/metrics/legacyregistry"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
 )
 
 type podRawInfo struct {
@@ -50,27 +70,67 @@ const (
 	namespace   = "test-ns"
 )
 
+// newTestNode returns a node of deviceCount GPU devices with totalMemory
+func newTestNode(name string) corev1.Node {
+	return *utiltesting.NewNode(name, deviceCount, totalMemory)
+}
+
+// newTestPod returns a pod of one container limited to given GPU cores and memory
+func newTestPod(name, cores, memory string) *corev1.Pod {
+	return utiltesting.NewPod(namespace, name,
+		utiltesting.NewContainer("container-0", cores, memory))
+}
+
+// newTestMember returns the i-th member of pod group job-0
+func newTestMember(i int, minMember, cores, memory string) *corev1.Pod {
+	pod := newTestPod("worker-"+strconv.Itoa(i), cores, memory)
+	pod.Annotations[util.PodGroupAnnotation] = "job-0"
+	pod.Annotations[util.PodGroupMinMemberAnnotation] = minMember
+	return pod
+}
+
+// waitForReady waits until caches of the filter are synced
+func waitForReady(t *testing.T, gpuFilter *GPUFilter) {
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return gpuFilter.Ready() == nil, nil
+	}); err != nil {
+		t.Fatalf("gpuFilter is not ready: %v", gpuFilter.Ready())
+	}
+}
+
+// waitForPod waits until the pod cache observes the pod satisfying cond
+func waitForPod(t *testing.T, gpuFilter *GPUFilter, name string, cond func(pod *corev1.Pod) bool) {
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		pod, err := gpuFilter.podLister.Pods(namespace).Get(name)
+		return err == nil && cond(pod), nil
+	}); err != nil {
+		t.Fatalf("pod %s is not observed", name)
+	}
+}
+
+// exists is the condition of waitForPod that the pod exists
+func exists(pod *corev1.Pod) bool {
+	return true
+}
+
+// predicated is the condition of waitForPod that the pod is predicated
+func predicated(pod *corev1.Pod) bool {
+	_, ok := pod.Annotations[util.PredicateNode]
+	return ok
+}
+
 func TestDeviceFilter(t *testing.T) {
 	k8sClient := fake.NewSimpleClientset()
 	gpuFilter, err := NewGPUFilter(k8sClient)
 	if err != nil {
 		t.Fatalf("failed to create new gpuFilter due to %v", err)
 	}
+	defer gpuFilter.Stop()
+	waitForReady(t, gpuFilter)
 
 	nodeList := []corev1.Node{}
 	for i := 0; i < 3; i++ {
-		n := corev1.Node{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: "testnode" + strconv.Itoa(i),
-			},
-			Status: corev1.NodeStatus{
-				Capacity: corev1.ResourceList{
-					util.VCoreAnnotation:   resource.MustParse(fmt.Sprintf("%d", deviceCount*util.HundredCore)),
-					util.VMemoryAnnotation: resource.MustParse(fmt.Sprintf("%d", totalMemory)),
-				},
-			},
-		}
-		nodeList = append(nodeList, n)
+		nodeList = append(nodeList, newTestNode("testnode"+strconv.Itoa(i)))
 	}
 	testCases := []podRawInfo{
 		{
@@ -155,36 +215,15 @@ func TestDeviceFilter(t *testing.T) {
 	for i, cs := range testCases {
 		containers := []corev1.Container{}
 		for _, c := range cs.Containers {
-			container := corev1.Container{
-				Name: c.Name,
-				Resources: corev1.ResourceRequirements{
-					Limits: corev1.ResourceList{
-						util.VCoreAnnotation:   resource.MustParse(fmt.Sprintf("%d", c.Cores)),
-						util.VMemoryAnnotation: resource.MustParse(fmt.Sprintf("%d", c.Memory)),
-					},
-				},
-			}
-			containers = append(containers, container)
-		}
-		pod := &corev1.Pod{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:        cs.Name,
-				UID:         k8stypes.UID(cs.UID),
-				Annotations: make(map[string]string),
-			},
-			Spec: corev1.PodSpec{
-				Containers: containers,
-			},
-			Status: corev1.PodStatus{
-				Phase: corev1.PodPending,
-			},
+			containers = append(containers, utiltesting.NewContainer(c.Name,
+				strconv.Itoa(c.Cores), strconv.Itoa(c.Memory)))
 		}
+		pod := utiltesting.NewPod(namespace, cs.Name, containers...)
+		pod.UID = k8stypes.UID(cs.UID)
 		pod, _ = k8sClient.CoreV1().Pods(namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+		waitForPod(t, gpuFilter, pod.Name, exists)
 
-		// wait for podLister to sync
-		time.Sleep(time.Second * 2)
-
-		nodes, failedNodes, err := gpuFilter.deviceFilter(pod, nodeList)
+		nodes, failedNodes, err := gpuFilter.deviceFilter(context.Background(), pod, nodeList)
 		if err != nil {
 			t.Fatalf("deviceFilter return err: %v", err)
 		}
@@ -195,14 +234,933 @@ func TestDeviceFilter(t *testing.T) {
 		if nodes[0].Name != testResults[i].nodeName {
 			t.Fatalf("choose the wrong node: %s, expect: %s", nodes[0].Name, testResults[i].nodeName)
 		}
-		// wait for podLister to sync
-		time.Sleep(time.Second * 2)
 
 		// get the latest pod and bind it to the node
 		pod, _ = k8sClient.CoreV1().Pods(namespace).Get(context.Background(), pod.Name, metav1.GetOptions{})
 		pod.Spec.NodeName = nodes[0].Name
 		pod.Status.Phase = corev1.PodRunning
-		pod, _ = k8sClient.CoreV1().Pods("test-ns").Update(context.Background(), pod, metav1.UpdateOptions{})
+		pod, _ = k8sClient.CoreV1().Pods(namespace).Update(context.Background(), pod, metav1.UpdateOptions{})
+		waitForPod(t, gpuFilter, pod.Name, func(pod *corev1.Pod) bool {
+			return pod.Spec.NodeName != ""
+		})
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset()
+	gpuFilter, err := NewGPUFilter(k8sClient)
+	if err != nil {
+		t.Fatalf("failed to create new gpuFilter due to %v", err)
+	}
+	defer gpuFilter.Stop()
+
+	for i := 0; i < 2; i++ {
+		n := newTestNode("testnode" + strconv.Itoa(i))
+		k8sClient.CoreV1().Nodes().Create(context.Background(), &n, metav1.CreateOptions{})
+	}
+	pod := newTestPod("pod-0", "200", "8")
+	k8sClient.CoreV1().Pods(namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+
+	waitForReady(t, gpuFilter)
+	waitForPod(t, gpuFilter, pod.Name, exists)
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		nodes, err := gpuFilter.nodeLister.List(labels.Everything())
+		return len(nodes) == 2, err
+	}); err != nil {
+		t.Fatalf("nodes are not observed: %v", err)
+	}
+
+	result := gpuFilter.Simulate(context.Background(),
+		SimulateArgs{Pod: pod, NodeNames: []string{"testnode1", "testnode2"}})
+	if result.Error != "" {
+		t.Fatalf("simulate return err: %s", result.Error)
+	}
+	if result.Node != "testnode1" {
+		t.Fatalf("choose the wrong node: %s, expect: testnode1", result.Node)
+	}
+	if len(result.Devices["container-0"]) != deviceCount {
+		t.Fatalf("wrong devices %v for container-0", result.Devices)
+	}
+	if _, ok := result.FailedNodes["testnode2"]; !ok {
+		t.Fatalf("missing failure reason of testnode2: %v", result.FailedNodes)
+	}
+
+	// the pod should not be updated by simulation
+	pod, _ = k8sClient.CoreV1().Pods(namespace).Get(context.Background(), pod.Name, metav1.GetOptions{})
+	if _, ok := pod.Annotations[util.PredicateNode]; ok {
+		t.Fatalf("pod should not be patched by simulation: %v", pod.Annotations)
+	}
+}
+
+func TestSummarizeFailure(t *testing.T) {
+	message := summarizeFailure(4, map[string]string{
+		"testnode0": reasonNoGPU,
+		"testnode1": algorithm.ReasonInsufficientCores,
+		"testnode2": algorithm.ReasonInsufficientCores,
+		"testnode3": algorithm.ReasonNoIdleDevice,
+	})
+	expect := "0/4 nodes are available: 1 no GPU device, 1 no idle GPU device, 2 insufficient GPU cores."
+	if message != expect {
+		t.Fatalf("wrong summary %q, expect %q", message, expect)
+	}
+}
+
+func TestGangFilter(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset()
+	gpuFilter, err := NewGPUFilter(k8sClient)
+	if err != nil {
+		t.Fatalf("failed to create new gpuFilter due to %v", err)
+	}
+	defer gpuFilter.Stop()
+	waitForReady(t, gpuFilter)
+
+	nodeList := []corev1.Node{newTestNode("testnode0"), newTestNode("testnode1")}
+	newMember := func(i int) *corev1.Pod {
+		pod, _ := k8sClient.CoreV1().Pods(namespace).Create(context.Background(),
+			newTestMember(i, "3", "100", "4"), metav1.CreateOptions{})
+		waitForPod(t, gpuFilter, pod.Name, exists)
+		return pod
+	}
+
+	pods := []*corev1.Pod{newMember(0), newMember(1)}
+	nodes, failedNodes, err := gpuFilter.deviceFilter(context.Background(), pods[0], nodeList)
+	if err != nil {
+		t.Fatalf("deviceFilter return err: %v", err)
+	}
+	if len(nodes) != 0 || !strings.Contains(failedNodes["testnode0"], "2/3 members") {
+		t.Fatalf("pod group should wait for members: %v, failedNodes: %v", nodes, failedNodes)
+	}
+
+	pods = append(pods, newMember(2))
+	nodes, failedNodes, err = gpuFilter.deviceFilter(context.Background(), pods[0], nodeList)
+	if err != nil {
+		t.Fatalf("deviceFilter return err: %v", err)
+	}
+	if len(nodes) != 1 {
+		t.Fatalf("deviceFilter should return exact one node: %v, failedNodes: %v", nodes, failedNodes)
+	}
+	for _, pod := range pods {
+		waitForPod(t, gpuFilter, pod.Name, predicated)
+	}
+
+	// other members are reserved to their predicated nodes
+	reserved := make(map[string]int)
+	for _, pod := range pods {
+		pod, _ = k8sClient.CoreV1().Pods(namespace).Get(context.Background(), pod.Name, metav1.GetOptions{})
+		nodeName, ok := pod.Annotations[util.PredicateNode]
+		if !ok {
+			t.Fatalf("member %s should be predicated", pod.Name)
+		}
+		reserved[nodeName]++
+		nodes, failedNodes, err := gpuFilter.deviceFilter(context.Background(), pod, nodeList)
+		if err != nil || len(nodes) != 1 || nodes[0].Name != nodeName {
+			t.Fatalf("member %s should be filtered to %s: %v, failedNodes: %v, err: %v",
+				pod.Name, nodeName, nodes, failedNodes, err)
+		}
+	}
+	if reserved["testnode0"]+reserved["testnode1"] != 3 {
+		t.Fatalf("wrong reservations: %v", reserved)
+	}
+}
+
+func TestGPUAllocationRecord(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset()
+	gpuClient := gpufake.NewSimpleClientset()
+	gpuFilter, err := NewGPUFilter(k8sClient, WithGPUAllocationRecord(gpuClient))
+	if err != nil {
+		t.Fatalf("failed to create new gpuFilter due to %v", err)
+	}
+	defer gpuFilter.Stop()
+
+	node := newTestNode("testnode0")
+	k8sClient.CoreV1().Nodes().Create(context.Background(), &node, metav1.CreateOptions{})
+	pod := utiltesting.NewPod(namespace, "pod-0", corev1.Container{Name: "container-without-gpu"},
+		utiltesting.NewContainer("container-0", "50", "2"))
+	pod, _ = k8sClient.CoreV1().Pods(namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+	waitForReady(t, gpuFilter)
+	waitForPod(t, gpuFilter, pod.Name, exists)
+
+	nodes, failedNodes, err := gpuFilter.deviceFilter(context.Background(), pod, []corev1.Node{node})
+	if err != nil || len(nodes) != 1 {
+		t.Fatalf("deviceFilter failed: %v, failedNodes: %v", err, failedNodes)
+	}
+	alloc, err := gpuClient.GpuV1alpha1().GPUAllocations(namespace).Get(context.Background(),
+		"pod-0-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get GPU allocation: %v", err)
+	}
+	if alloc.Spec.PodUID != pod.UID || alloc.Spec.Container != "container-0" ||
+		alloc.Spec.NodeName != node.Name || len(alloc.Spec.DeviceIndexes) != 1 ||
+		alloc.Labels[util.PredicateNode] != node.Name || len(alloc.OwnerReferences) != 1 {
+		t.Fatalf("wrong GPU allocation: %+v", alloc)
+	}
+	predicatedPod, _ := k8sClient.CoreV1().Pods(namespace).Get(context.Background(), pod.Name,
+		metav1.GetOptions{})
+	if v := strconv.FormatInt(alloc.Status.PredicateTime.UnixNano(), 10); v !=
+		predicatedPod.Annotations[util.PredicateTimeAnnotation] {
+		t.Fatalf("predicate time %s differs from the annotation %s", v,
+			predicatedPod.Annotations[util.PredicateTimeAnnotation])
+	}
+
+	// the allocation state is rebuilt from the object rather than the annotations
+	index := alloc.Spec.DeviceIndexes[0]
+	alloc.Spec.DeviceIndexes = []int{1 - index}
+	gpuClient.GpuV1alpha1().GPUAllocations(namespace).Update(context.Background(), alloc,
+		metav1.UpdateOptions{})
+
+	var used uint
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		nodeInfo, err := gpuFilter.GetNodeInfo(node.Name)
+		if err != nil {
+			return false, err
+		}
+		used = nodeInfo.GetDeviceMap()[1-index].UsedCores()
+		return used == 50, nil
+	}); err != nil {
+		t.Fatalf("used cores of device %d is %d, expect 50: %v", 1-index, used, err)
+	}
+}
+
+func TestFilterBeforeCacheSync(t *testing.T) {
+	pod := newTestPod("pod-0", "50", "2")
+
+	// caches of a filter not started are never synced
+	result := (&GPUFilter{apiBreaker: breaker.New(breaker.Config{})}).Filter(context.Background(),
+		extenderv1.ExtenderArgs{Pod: pod})
+	if result.Error != ErrNotReady.Error() || result.Nodes != nil {
+		t.Fatalf("filter should fail before caches synced: %+v", result)
+	}
+
+	gpuFilter, err := NewGPUFilter(fake.NewSimpleClientset())
+	if err != nil {
+		t.Fatalf("failed to create new gpuFilter due to %v", err)
+	}
+	defer gpuFilter.Stop()
+	waitForReady(t, gpuFilter)
+}
+
+func TestParallelism(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset()
+	var nodeNames []string
+	for i := 0; i < 8; i++ {
+		n := utiltesting.NewNode("testnode"+strconv.Itoa(i), deviceCount, totalMemory)
+		k8sClient.CoreV1().Nodes().Create(context.Background(), n, metav1.CreateOptions{})
+		nodeNames = append(nodeNames, n.Name)
+
+		// nodes are used differently, the ones with odd index are full
+		cores := 10 * (i + 1)
+		if i%2 == 1 {
+			cores = util.HundredCore
+		}
+		for j := 0; j < deviceCount; j++ {
+			pod := newTestPod(fmt.Sprintf("running-%d-%d", i, j), strconv.Itoa(cores), "1")
+			pod.Annotations = map[string]string{
+				util.PredicateNode:                 n.Name,
+				util.PredicateGPUIndexPrefix + "0": strconv.Itoa(j),
+				util.GPUAssigned:                   "true",
+				util.PredicateTimeAnnotation:       "0",
+			}
+			pod.Spec.NodeName = n.Name
+			pod.Status.Phase = corev1.PodRunning
+			k8sClient.CoreV1().Pods(namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+		}
+	}
+	pod := newTestPod("pod-0", "50", "1")
+
+	// the result should not depend on the number of workers, invalid ones are taken as 1
+	var results []*SimulateResult
+	for _, parallelism := range []int{1, 4, 16, 0, -1} {
+		gpuFilter, err := NewGPUFilter(k8sClient, WithParallelism(parallelism))
+		if err != nil {
+			t.Fatalf("failed to create new gpuFilter due to %v", err)
+		}
+		defer gpuFilter.Stop()
+		waitForReady(t, gpuFilter)
+		results = append(results, gpuFilter.Simulate(context.Background(),
+			SimulateArgs{Pod: pod, NodeNames: nodeNames}))
+	}
+	for _, result := range results {
+		if result.Error != "" {
+			t.Fatalf("simulate return err: %s", result.Error)
+		}
+		if result.Node != results[0].Node ||
+			!reflect.DeepEqual(result.FailedNodes, results[0].FailedNodes) {
+			t.Fatalf("result %+v differs from %+v", result, results[0])
+		}
+	}
+	// the node with the least allocatable cores which fits
+	if results[0].Node != "testnode4" {
+		t.Fatalf("choose the wrong node: %s, expect: testnode4", results[0].Node)
+	}
+}
+
+func TestNumFeasibleNodesToFind(t *testing.T) {
+	testCases := []struct {
+		percentage  int
+		numAllNodes int
+		expected    int
+	}{
+		{percentage: 100, numAllNodes: 1000, expected: 1000},
+		{percentage: 10, numAllNodes: 50, expected: 50},
+		{percentage: 10, numAllNodes: 500, expected: 100},
+		{percentage: 40, numAllNodes: 1000, expected: 400},
+		{percentage: 0, numAllNodes: 1000, expected: 420},
+		{percentage: 0, numAllNodes: 10000, expected: 500},
+	}
+	for _, cs := range testCases {
+		gpuFilter := &GPUFilter{percentageOfNodesToScore: cs.percentage}
+		if num := gpuFilter.numFeasibleNodesToFind(cs.numAllNodes); num != cs.expected {
+			t.Errorf("percentage %d of %d nodes: expect %d, got %d", cs.percentage,
+				cs.numAllNodes, cs.expected, num)
+		}
+	}
+}
+
+func TestPercentageOfNodesToScore(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset()
+	var nodes []corev1.Node
+	for i := 0; i < 200; i++ {
+		nodes = append(nodes, newTestNode(fmt.Sprintf("testnode%03d", i)))
+	}
+	newPod := func(name, nodeName string) *corev1.Pod {
+		pod := newTestPod(name, "50", "1")
+		pod.Spec.NodeName = nodeName
+		if nodeName != "" {
+			pod.Annotations[util.PredicateNode] = nodeName
+			pod.Annotations[util.PredicateGPUIndexPrefix+"0"] = "0"
+			pod.Status.Phase = corev1.PodRunning
+		}
+		k8sClient.CoreV1().Pods(namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+		return pod
+	}
+	// testnode150 is the most suitable one as it's partially used
+	newPod("running", "testnode150")
+	pod := newPod("pod-0", "")
+
+	gpuFilter, err := NewGPUFilter(k8sClient, WithParallelism(1), WithPercentageOfNodesToScore(10))
+	if err != nil {
+		t.Fatalf("failed to create new gpuFilter due to %v", err)
+	}
+	defer gpuFilter.Stop()
+	waitForReady(t, gpuFilter)
+
+	// the search stops after the first 100 nodes
+	result := gpuFilter.predicate(context.Background(), pod, nodes, false)
+	if result.node == nil || result.node.Name != "testnode000" {
+		t.Fatalf("choose the wrong node: %v, expect: testnode000", result.node)
+	}
+	if len(result.failedNodes) != len(nodes)-1 {
+		t.Fatalf("expect %d failed nodes, got %d", len(nodes)-1, len(result.failedNodes))
+	}
+	// the next search starts from where the last one stopped
+	result = gpuFilter.predicate(context.Background(), pod, nodes, false)
+	if result.node == nil || result.node.Name != "testnode150" {
+		t.Fatalf("choose the wrong node: %v, expect: testnode150", result.node)
+	}
+}
+
+func TestFilterCancelled(t *testing.T) {
+	k8sClient := fake.NewSimpleClientset()
+	node := utiltesting.NewNode("testnode0", deviceCount, totalMemory)
+	pod := newTestPod("pod-0", "50", "1")
+	k8sClient.CoreV1().Pods(namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+
+	gpuFilter, err := NewGPUFilter(k8sClient)
+	if err != nil {
+		t.Fatalf("failed to create new gpuFilter due to %v", err)
+	}
+	defer gpuFilter.Stop()
+	waitForReady(t, gpuFilter)
+	args := extenderv1.ExtenderArgs{Pod: pod, Nodes: &corev1.NodeList{Items: []corev1.Node{*node}}}
+
+	// the scheduler has given up before the filter starts
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if result := gpuFilter.Filter(ctx, args); result.Error == "" {
+		t.Fatalf("filter of a cancelled request should fail: %+v", result)
+	}
+
+	// the scheduler gives up while the pod is being patched
+	ctx, cancel = context.WithCancel(context.Background())
+	k8sClient.PrependReactor("patch", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		cancel()
+		return false, nil, nil
+	})
+	if result := gpuFilter.Filter(ctx, args); result.Error == "" {
+		t.Fatalf("filter of a cancelled request should fail: %+v", result)
+	}
+	pod, _ = k8sClient.CoreV1().Pods(namespace).Get(context.Background(), pod.Name, metav1.GetOptions{})
+	for k := range pod.Annotations {
+		if strings.HasPrefix(k, util.PredicateGPUIndexPrefix) || k == util.PredicateNode {
+			t.Fatalf("reservation should be released: %v", pod.Annotations)
+		}
+	}
+}
+
+func TestFailurePolicy(t *testing.T) {
+	node := newTestNode("testnode0")
+	newPod := func() *corev1.Pod {
+		return newTestPod("pod-0", "50", "1")
+	}
+	// newFilter returns a filter whose API server is degraded until recovered is set
+	newFilter := func(policy FailurePolicy) (*GPUFilter, *fake.Clientset, *int32) {
+		var recovered int32
+		k8sClient := fake.NewSimpleClientset(&node)
+		k8sClient.CoreV1().Pods(namespace).Create(context.Background(), newPod(), metav1.CreateOptions{})
+		k8sClient.PrependReactor("patch", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if atomic.LoadInt32(&recovered) == 0 {
+				return true, nil, apierrors.NewServiceUnavailable("degraded")
+			}
+			return false, nil, nil
+		})
+		k8sClient.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return action.GetSubresource() == "binding", nil, nil
+		})
+		gpuFilter, err := NewGPUFilter(k8sClient, WithFailurePolicy(policy, breaker.Config{
+			FailureThreshold: 1,
+			Cooldown:         100 * time.Millisecond,
+		}))
+		if err != nil {
+			t.Fatalf("failed to create new gpuFilter due to %v", err)
+		}
+		waitForReady(t, gpuFilter)
+		return gpuFilter, k8sClient, &recovered
+	}
+	args := extenderv1.ExtenderArgs{Pod: newPod(), Nodes: &corev1.NodeList{Items: []corev1.Node{node}}}
+
+	// the failed patch opens the breaker, then pods are unschedulable
+	gpuFilter, _, _ := newFilter(FailClosed)
+	defer gpuFilter.Stop()
+	if result := gpuFilter.Filter(context.Background(), args); len(result.Nodes.Items) != 0 {
+		t.Fatalf("filter should fail when patch fails: %+v", result)
+	}
+	result := gpuFilter.Filter(context.Background(), args)
+	if result.Error != "" || result.FailedNodes[node.Name] != breaker.ErrOpen.Error() {
+		t.Fatalf("filter should fail closed: %+v", result)
+	}
+	if gpuFilter.Ready() != breaker.ErrOpen {
+		t.Fatalf("filter failing closed should not be ready: %v", gpuFilter.Ready())
+	}
+
+	// pods are placed without reservations, which are made at binding
+	gpuFilter, k8sClient, recovered := newFilter(FailOpen)
+	defer gpuFilter.Stop()
+	gpuFilter.Filter(context.Background(), args)
+	result = gpuFilter.Filter(context.Background(), args)
+	if result.Error != "" || len(result.Nodes.Items) != 1 {
+		t.Fatalf("filter should fail open: %+v", result)
 	}
+	if gpuFilter.Ready() != nil {
+		t.Fatalf("filter failing open should be ready: %v", gpuFilter.Ready())
+	}
+	atomic.StoreInt32(recovered, 1)
+	// the breaker admits a trial call after cooldown
+	var bindResult *extenderv1.ExtenderBindingResult
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		bindResult = gpuFilter.Bind(context.Background(), extenderv1.ExtenderBindingArgs{
+			PodName:      "pod-0",
+			PodNamespace: namespace,
+			PodUID:       "pod-0",
+			Node:         node.Name,
+		})
+		return bindResult.Error == "", nil
+	}); err != nil {
+		t.Fatalf("bind failed: %s", bindResult.Error)
+	}
+	pod, _ := k8sClient.CoreV1().Pods(namespace).Get(context.Background(), "pod-0", metav1.GetOptions{})
+	if pod.Annotations[util.PredicateNode] != node.Name {
+		t.Fatalf("reservation should be made at binding: %v", pod.Annotations)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	auditLogger, err := audit.NewLogger(filepath.Join(dir, "audit.log"), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create audit logger: %v", err)
+	}
+
+	var nodes []corev1.Node
+	for i := 0; i < 2; i++ {
+		nodes = append(nodes, newTestNode(fmt.Sprintf("testnode%d", i)))
+	}
+	nodes = append(nodes, corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "cpunode"}})
 
+	k8sClient := fake.NewSimpleClientset()
+	newPod := func(name, nodeName string) *corev1.Pod {
+		pod := newTestPod(name, "50", "1")
+		pod.Spec.NodeName = nodeName
+		if nodeName != "" {
+			pod.Annotations[util.PredicateNode] = nodeName
+			pod.Annotations[util.PredicateGPUIndexPrefix+"0"] = "1"
+			pod.Status.Phase = corev1.PodRunning
+		}
+		k8sClient.CoreV1().Pods(namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+		return pod
+	}
+	newPod("running", "testnode1")
+	pod := newPod("pod-0", "")
+
+	gpuFilter, err := NewGPUFilter(k8sClient, WithAuditLogger(auditLogger))
+	if err != nil {
+		t.Fatalf("failed to create new gpuFilter due to %v", err)
+	}
+	defer gpuFilter.Stop()
+	waitForReady(t, gpuFilter)
+	result := gpuFilter.Filter(context.Background(), extenderv1.ExtenderArgs{
+		Pod:   pod,
+		Nodes: &corev1.NodeList{Items: nodes},
+	})
+	if result.Error != "" {
+		t.Fatalf("filter failed: %s", result.Error)
+	}
+	auditLogger.Close()
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	var record audit.Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("invalid audit record %s: %v", data, err)
+	}
+	if record.Pod.Name != "pod-0" || record.Result != metrics.ResultPredicated {
+		t.Fatalf("unexpected audit record: %s", data)
+	}
+	expectedContainers := []audit.Container{{Name: "container-0", Cores: 50, Memory: 1}}
+	if !reflect.DeepEqual(record.Containers, expectedContainers) {
+		t.Errorf("expect containers %v, got %v", expectedContainers, record.Containers)
+	}
+	if !reflect.DeepEqual(record.Order, []string{"testnode1", "testnode0"}) {
+		t.Errorf("expect order [testnode1 testnode0], got %v", record.Order)
+	}
+	if record.Node != "testnode1" || !reflect.DeepEqual(record.Devices["container-0"], []int{1}) {
+		t.Errorf("expect placement on testnode1 device 1, got %s %v", record.Node, record.Devices)
+	}
+	if _, ok := record.FailedNodes["cpunode"]; !ok || len(record.FailedNodes) != 2 {
+		t.Errorf("expect cpunode and testnode0 to fail, got %v", record.FailedNodes)
+	}
+	// candidates are recorded before the pod is placed
+	for _, candidate := range record.Candidates {
+		if candidate.Name == "testnode1" && candidate.Devices[1].AllocatableCores != 50 {
+			t.Errorf("expect 50 allocatable cores of device 1 before placement, got %v", candidate)
+		}
+	}
+	if len(record.Candidates) != 2 {
+		t.Errorf("expect 2 candidates, got %v", record.Candidates)
+	}
+}
+
+func TestAuditLogReservedMember(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	auditLogger, err := audit.NewLogger(filepath.Join(dir, "audit.log"), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create audit logger: %v", err)
+	}
+
+	nodes := []corev1.Node{newTestNode("testnode0"), newTestNode("testnode1")}
+	newMember := func(i int) *corev1.Pod {
+		return newTestMember(i, "2", "50", "1")
+	}
+	k8sClient := fake.NewSimpleClientset(newMember(0), newMember(1))
+	gpuFilter, err := NewGPUFilter(k8sClient, WithAuditLogger(auditLogger))
+	if err != nil {
+		t.Fatalf("failed to create new gpuFilter due to %v", err)
+	}
+	defer gpuFilter.Stop()
+	waitForReady(t, gpuFilter)
+
+	if _, _, err := gpuFilter.deviceFilter(context.Background(), newMember(0), nodes); err != nil {
+		t.Fatalf("deviceFilter return err: %v", err)
+	}
+	worker1, _ := k8sClient.CoreV1().Pods(namespace).Get(context.Background(), "worker-1",
+		metav1.GetOptions{})
+	reserved := worker1.Annotations[util.PredicateNode]
+	if _, _, err := gpuFilter.deviceFilter(context.Background(), worker1, nodes); err != nil {
+		t.Fatalf("deviceFilter return err: %v", err)
+	}
+	auditLogger.Close()
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	// the members admitted at once, then worker-1 filtered to its reserved node
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expect 3 audit records, got %s", data)
+	}
+	var record audit.Record
+	if err := json.Unmarshal([]byte(lines[2]), &record); err != nil {
+		t.Fatalf("invalid audit record %s: %v", lines[2], err)
+	}
+	if record.Pod.Name != "worker-1" || record.Result != metrics.ResultPredicated ||
+		record.Node != reserved || len(record.Devices["container-0"]) != 1 ||
+		len(record.FailedNodes) != 1 {
+		t.Fatalf("unexpected audit record: %s", lines[2])
+	}
+}
+
+func TestPredicationEvents(t *testing.T) {
+	node := newTestNode("testnode0")
+	newPod := func(name string, cores string) *corev1.Pod {
+		return newTestPod(name, cores, "1")
+	}
+	fitPod, unfitPod := newPod("pod-0", "50"), newPod("pod-1", "300")
+	k8sClient := fake.NewSimpleClientset(&node, fitPod, unfitPod)
+	gpuFilter, err := NewGPUFilter(k8sClient)
+	if err != nil {
+		t.Fatalf("failed to create new gpuFilter due to %v", err)
+	}
+	defer gpuFilter.Stop()
+	recorder := record.NewFakeRecorder(10)
+	gpuFilter.recorder = recorder
+	waitForReady(t, gpuFilter)
+
+	nodes := []corev1.Node{node}
+	if _, _, err := gpuFilter.deviceFilter(context.Background(), fitPod, nodes); err != nil {
+		t.Fatalf("deviceFilter return err: %v", err)
+	}
+	if _, _, err := gpuFilter.deviceFilter(context.Background(), unfitPod, nodes); err != nil {
+		t.Fatalf("deviceFilter return err: %v", err)
+	}
+
+	expected := []string{
+		"Normal GPUPredicated Predicated to node testnode0 with GPU devices container-0: 0",
+		"Warning FailedGPUPredicate 0/1 nodes are available: 1 no idle GPU device.",
+	}
+	for _, event := range expected {
+		select {
+		case got := <-recorder.Events:
+			if got != event {
+				t.Errorf("expect event %q, got %q", event, got)
+			}
+		default:
+			t.Errorf("event %q is not recorded", event)
+		}
+	}
+}
+
+func TestGangFilterMinMember(t *testing.T) {
+	// one node of 2 devices fits 2 of 3 workers
+	node := newTestNode("testnode0")
+	newMember := func(i int) *corev1.Pod {
+		return newTestMember(i, "2", "100", "4")
+	}
+	k8sClient := fake.NewSimpleClientset(&node, newMember(0), newMember(1), newMember(2))
+	gpuFilter, err := NewGPUFilter(k8sClient)
+	if err != nil {
+		t.Fatalf("failed to create new gpuFilter due to %v", err)
+	}
+	defer gpuFilter.Stop()
+	waitForReady(t, gpuFilter)
+	nodeList := []corev1.Node{node}
+
+	// the minimal number of members is admitted though not every member fits
+	nodes, failedNodes, err := gpuFilter.deviceFilter(context.Background(), newMember(0), nodeList)
+	if err != nil || len(nodes) != 1 {
+		t.Fatalf("pod group should be admitted: %v, failedNodes: %v, err: %v", nodes, failedNodes, err)
+	}
+	var worker2 *corev1.Pod
+	for i := 0; i < 3; i++ {
+		pod, _ := k8sClient.CoreV1().Pods(namespace).Get(context.Background(),
+			"worker-"+strconv.Itoa(i), metav1.GetOptions{})
+		if _, ok := pod.Annotations[util.PredicateNode]; ok != (i < 2) {
+			t.Fatalf("only worker-0 and worker-1 should be predicated, worker-%d: %v", i,
+				pod.Annotations)
+		}
+		worker2 = pod
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		pods, err := gpuFilter.ListPodsOnNode(&node)
+		return len(pods) == 2, err
+	}); err != nil {
+		t.Fatalf("predicated members are not observed: %v", err)
+	}
+
+	// the rest is filtered as an ordinary pod
+	nodes, failedNodes, err = gpuFilter.deviceFilter(context.Background(), worker2, nodeList)
+	if err != nil || len(nodes) != 0 || failedNodes[node.Name] == "" {
+		t.Fatalf("worker-2 should not fit: %v, failedNodes: %v, err: %v", nodes, failedNodes, err)
+	}
+	if strings.Contains(failedNodes[node.Name], "pod group") {
+		t.Fatalf("worker-2 should be filtered as an ordinary pod: %v", failedNodes)
+	}
+}
+
+func TestGangFilterRollback(t *testing.T) {
+	node := newTestNode("testnode0")
+	newMember := func(i int) *corev1.Pod {
+		return newTestMember(i, "2", "50", "1")
+	}
+	k8sClient := fake.NewSimpleClientset(&node, newMember(0), newMember(1))
+	// predicating worker-1 fails after worker-0 has been predicated
+	k8sClient.PrependReactor("patch", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetName() == "worker-1" && strings.Contains(string(patch.GetPatch()), util.PredicateNode+`":"`) {
+			return true, nil, apierrors.NewForbidden(corev1.Resource("pods"), "worker-1", fmt.Errorf("denied"))
+		}
+		return false, nil, nil
+	})
+	gpuFilter, err := NewGPUFilter(k8sClient)
+	if err != nil {
+		t.Fatalf("failed to create new gpuFilter due to %v", err)
+	}
+	defer gpuFilter.Stop()
+	waitForReady(t, gpuFilter)
+
+	nodes, failedNodes, err := gpuFilter.deviceFilter(context.Background(), newMember(0),
+		[]corev1.Node{node})
+	if err != nil || len(nodes) != 0 || !strings.Contains(failedNodes[node.Name], reasonPatch) {
+		t.Fatalf("pod group should not be admitted: %v, failedNodes: %v, err: %v", nodes,
+			failedNodes, err)
+	}
+	for i := 0; i < 2; i++ {
+		pod, _ := k8sClient.CoreV1().Pods(namespace).Get(context.Background(),
+			"worker-"+strconv.Itoa(i), metav1.GetOptions{})
+		if len(predicateAnnotations(pod)) != 0 {
+			t.Fatalf("reservation of worker-%d should be rolled back: %v", i, pod.Annotations)
+		}
+	}
+}
+
+func TestGangFilterRollbackBreakerOpen(t *testing.T) {
+	node := newTestNode("testnode0")
+	newMember := func(i int) *corev1.Pod {
+		return newTestMember(i, "2", "50", "1")
+	}
+	k8sClient := fake.NewSimpleClientset(&node, newMember(0), newMember(1))
+	// the API server fails predicating worker-1, which opens the breaker
+	k8sClient.PrependReactor("patch", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetName() == "worker-1" && strings.Contains(string(patch.GetPatch()), util.PredicateNode+`":"`) {
+			return true, nil, apierrors.NewServiceUnavailable("degraded")
+		}
+		return false, nil, nil
+	})
+	gpuFilter, err := NewGPUFilter(k8sClient, WithFailurePolicy(FailClosed, breaker.Config{
+		FailureThreshold: 1,
+		Cooldown:         time.Minute,
+	}))
+	if err != nil {
+		t.Fatalf("failed to create new gpuFilter due to %v", err)
+	}
+	defer gpuFilter.Stop()
+	waitForReady(t, gpuFilter)
+
+	nodes, failedNodes, err := gpuFilter.deviceFilter(context.Background(), newMember(0),
+		[]corev1.Node{node})
+	if err != nil || len(nodes) != 0 {
+		t.Fatalf("pod group should not be admitted: %v, failedNodes: %v, err: %v", nodes,
+			failedNodes, err)
+	}
+	if gpuFilter.Ready() != breaker.ErrOpen {
+		t.Fatalf("breaker should be open: %v", gpuFilter.Ready())
+	}
+	// the rollback is not rejected by the open breaker
+	pod, _ := k8sClient.CoreV1().Pods(namespace).Get(context.Background(), "worker-0",
+		metav1.GetOptions{})
+	if len(predicateAnnotations(pod)) != 0 {
+		t.Fatalf("reservation of worker-0 should be rolled back: %v", pod.Annotations)
+	}
+}
+
+func TestPatchRetriesCountedOnce(t *testing.T) {
+	pod := newTestPod("pod-0", "50", "1")
+	k8sClient := fake.NewSimpleClientset(pod)
+	// the first two patches time out and are retried
+	var patches int32
+	k8sClient.PrependReactor("patch", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if atomic.AddInt32(&patches, 1) <= 2 {
+			return true, nil, apierrors.NewServerTimeout(corev1.Resource("pods"), "patch", 0)
+		}
+		return false, nil, nil
+	})
+	gpuFilter, err := NewGPUFilter(k8sClient, WithFailurePolicy(FailClosed, breaker.Config{
+		FailureThreshold: 2,
+		Cooldown:         time.Minute,
+	}))
+	if err != nil {
+		t.Fatalf("failed to create new gpuFilter due to %v", err)
+	}
+	defer gpuFilter.Stop()
+	waitForReady(t, gpuFilter)
+
+	if err := gpuFilter.patchPodWithAnnotations(context.Background(), pod,
+		map[string]string{util.GPUAssigned: "false"}); err != nil {
+		t.Fatalf("patch should succeed after retries: %v", err)
+	}
+	if gpuFilter.Ready() != nil {
+		t.Fatalf("retries of one patch should not open the breaker: %v", gpuFilter.Ready())
+	}
+}
+
+func TestGangFilterReservedNodeNotCandidate(t *testing.T) {
+	node := newTestNode("testnode0")
+	newMember := func(i int) *corev1.Pod {
+		return newTestMember(i, "2", "50", "1")
+	}
+	k8sClient := fake.NewSimpleClientset(&node, newMember(0), newMember(1))
+	gpuFilter, err := NewGPUFilter(k8sClient)
+	if err != nil {
+		t.Fatalf("failed to create new gpuFilter due to %v", err)
+	}
+	defer gpuFilter.Stop()
+	waitForReady(t, gpuFilter)
+
+	nodes, failedNodes, err := gpuFilter.deviceFilter(context.Background(), newMember(0),
+		[]corev1.Node{node})
+	if err != nil || len(nodes) != 1 {
+		t.Fatalf("pod group should be admitted: %v, failedNodes: %v, err: %v", nodes, failedNodes, err)
+	}
+	waitForPod(t, gpuFilter, "worker-1", predicated)
+
+	// the reserved node is gone, e.g. it's cordoned, so the group is released
+	worker1, _ := k8sClient.CoreV1().Pods(namespace).Get(context.Background(), "worker-1",
+		metav1.GetOptions{})
+	other := newTestNode("testnode1")
+	nodes, failedNodes, err = gpuFilter.deviceFilter(context.Background(), worker1,
+		[]corev1.Node{other})
+	if err != nil || len(nodes) != 0 || !strings.Contains(failedNodes[other.Name], "released") {
+		t.Fatalf("reservations should be released: %v, failedNodes: %v, err: %v", nodes,
+			failedNodes, err)
+	}
+	for i := 0; i < 2; i++ {
+		pod, _ := k8sClient.CoreV1().Pods(namespace).Get(context.Background(),
+			"worker-"+strconv.Itoa(i), metav1.GetOptions{})
+		if len(predicateAnnotations(pod)) != 0 {
+			t.Fatalf("reservation of worker-%d should be released: %v", i, pod.Annotations)
+		}
+	}
+}
+
+func TestFilterMetrics(t *testing.T) {
+	metrics.Register()
+	node := newTestNode("testnode0")
+	pod := newTestPod("pod-0", "50", "1")
+	k8sClient := fake.NewSimpleClientset(&node, pod)
+	// the first patch conflicts and is retried
+	var patches int32
+	k8sClient.PrependReactor("patch", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if atomic.AddInt32(&patches, 1) == 1 {
+			return true, nil, apierrors.NewConflict(corev1.Resource("pods"), "pod-0", fmt.Errorf("conflict"))
+		}
+		return false, nil, nil
+	})
+	gpuFilter, err := NewGPUFilter(k8sClient)
+	if err != nil {
+		t.Fatalf("failed to create new gpuFilter due to %v", err)
+	}
+	defer gpuFilter.Stop()
+	waitForReady(t, gpuFilter)
+
+	// value returns the sum of samples of the metric with given labels, vector
+	// metrics without any sample are not gathered
+	value := func(name string, labels map[string]string) float64 {
+		families, err := legacyregistry.DefaultGatherer.Gather()
+		if err != nil {
+			t.Fatalf("failed to gather metrics: %v", err)
+		}
+		var sum float64
+		for _, family := range families {
+			if family.GetName() != name {
+				continue
+			}
+		metric:
+			for _, m := range family.GetMetric() {
+				for _, label := range m.GetLabel() {
+					if v, ok := labels[label.GetName()]; ok && v != label.GetValue() {
+						continue metric
+					}
+				}
+				switch {
+				case m.GetCounter() != nil:
+					sum += m.GetCounter().GetValue()
+				case m.GetGauge() != nil:
+					sum += m.GetGauge().GetValue()
+				case m.GetHistogram() != nil:
+					sum += float64(m.GetHistogram().GetSampleCount())
+				}
+			}
+			return sum
+		}
+		return 0
+	}
+	type sample struct {
+		name   string
+		labels map[string]string
+	}
+	samples := []sample{
+		{name: "gpu_admission_filter_duration_seconds"},
+		{name: "gpu_admission_filter_stage_duration_seconds", labels: map[string]string{"stage": metrics.StageListPods}},
+		{name: "gpu_admission_filter_stage_duration_seconds", labels: map[string]string{"stage": metrics.StageBuildNodeInfo}},
+		{name: "gpu_admission_filter_stage_duration_seconds", labels: map[string]string{"stage": metrics.StageAllocate}},
+		{name: "gpu_admission_filter_stage_duration_seconds", labels: map[string]string{"stage": metrics.StagePatch}},
+		{name: "gpu_admission_predicated_pods_total", labels: map[string]string{
+			"mode": metrics.ModeShared, "result": metrics.ResultPredicated}},
+		{name: "gpu_admission_patch_retries_total"},
+	}
+	before := make([]float64, len(samples))
+	for i, s := range samples {
+		before[i] = value(s.name, s.labels)
+	}
+
+	result := gpuFilter.Filter(context.Background(), extenderv1.ExtenderArgs{
+		Pod:   pod,
+		Nodes: &corev1.NodeList{Items: []corev1.Node{node}},
+	})
+	if result.Error != "" || len(result.Nodes.Items) != 1 {
+		t.Fatalf("filter failed: %+v", result)
+	}
+
+	for i, s := range samples {
+		if after := value(s.name, s.labels); after <= before[i] {
+			t.Errorf("metric %s%v is not emitted: %v before, %v after", s.name, s.labels,
+				before[i], after)
+		}
+	}
+	families, err := legacyregistry.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	var breakerState bool
+	for _, family := range families {
+		if family.GetName() == "gpu_admission_api_breaker_state" {
+			breakerState = true
+		}
+	}
+	if !breakerState {
+		t.Errorf("metric gpu_admission_api_breaker_state is not emitted")
+	}
+	if state := value("gpu_admission_api_breaker_state", nil); state != 0 {
+		t.Errorf("api breaker should be closed, got state %v", state)
+	}
+}
+
+func TestIsAPIFailure(t *testing.T) {
+	testCases := []struct {
+		err     error
+		failure bool
+	}{
+		{err: nil, failure: false},
+		{err: context.Canceled, failure: false},
+		{err: context.DeadlineExceeded, failure: false},
+		{err: fmt.Errorf("patch: %w", context.DeadlineExceeded), failure: false},
+		{err: apierrors.NewForbidden(corev1.Resource("pods"), "pod-0", fmt.Errorf("denied")), failure: false},
+		{err: apierrors.NewServiceUnavailable("degraded"), failure: true},
+		{err: fmt.Errorf("connection refused"), failure: true},
+	}
+	for _, cs := range testCases {
+		if failure := isAPIFailure(cs.err); failure != cs.failure {
+			t.Errorf("error %v should be an API failure: %t, got %t", cs.err, cs.failure, failure)
+		}
+	}
 }
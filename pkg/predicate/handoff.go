@@ -0,0 +1,93 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package predicate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// handoffTimeout is how long the new leader waits for its cache to observe the
+// reservations before the handoff is retried
+const handoffTimeout = 30 * time.Second
+
+// Handoff makes sure a new leader knows every reservation made by the previous one.
+// The reservations live in pod annotations, so it reads the predicated pods which
+// are not bound from the API server, and waits until the pod cache has observed all
+// of them with the same predication. The previous leader cancels its in-flight
+// requests and waits for them to finish before it releases the Lease, or before the
+// Lease it can't renew expires, so no reservation is made after the read.
+func (gpuFilter *GPUFilter) Handoff(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, handoffTimeout)
+	defer cancel()
+
+	err := wait.PollImmediateUntil(100*time.Millisecond, func() (bool, error) {
+		return gpuFilter.Ready() == nil, nil
+	}, ctx.Done())
+	if err != nil {
+		return ErrNotReady
+	}
+
+	podList, err := gpuFilter.kubeClient.CoreV1().Pods(metav1.NamespaceAll).List(ctx,
+		metav1.ListOptions{
+			FieldSelector: fmt.Sprintf("%s!=%s", PodPhaseField, corev1.PodSucceeded),
+		})
+	if err != nil {
+		return err
+	}
+	var reserved []*corev1.Pod
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if _, ok := pod.Annotations[util.PredicateNode]; ok && pod.Spec.NodeName == "" {
+			reserved = append(reserved, pod)
+		}
+	}
+
+	err = wait.PollImmediateUntil(100*time.Millisecond, func() (bool, error) {
+		for _, pod := range reserved {
+			if !gpuFilter.observed(pod) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}, ctx.Done())
+	if err != nil {
+		return fmt.Errorf("cache hasn't observed %d reservations: %v", len(reserved), err)
+	}
+	klog.Infof("handoff finished with %d reservations observed", len(reserved))
+	return nil
+}
+
+// observed tells if the pod cache has the pod with the same predication, or the pod
+// has been bound since then. A pod deleted during the handoff fails it, and the
+// retry doesn't read it any more.
+func (gpuFilter *GPUFilter) observed(pod *corev1.Pod) bool {
+	cached, err := gpuFilter.podLister.Pods(pod.Namespace).Get(pod.Name)
+	if err != nil || cached.UID != pod.UID {
+		return false
+	}
+	return cached.Spec.NodeName != "" ||
+		cached.Annotations[util.PredicateTimeAnnotation] == pod.Annotations[util.PredicateTimeAnnotation]
+}
@@ -17,6 +17,9 @@
 package predicate
 
 import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
 	extenderv1 "k8s.io/kube-scheduler/extender/v1"
 )
 
@@ -24,6 +27,38 @@ type Predicate interface {
 	// Name returns the name of this predictor
 	Name() string
 	// Filter returns the filter result of predictor, this will tell the suitable nodes to running
-	// pod
-	Filter(args extenderv1.ExtenderArgs) *extenderv1.ExtenderFilterResult
+	// pod. The work is stopped once ctx is done.
+	Filter(ctx context.Context, args extenderv1.ExtenderArgs) *extenderv1.ExtenderFilterResult
+}
+
+// Binder binds pods to nodes on behalf of the scheduler
+type Binder interface {
+	// Bind binds the pod to the node, the reservation deferred by filter is made
+	// before binding
+	Bind(ctx context.Context, args extenderv1.ExtenderBindingArgs) *extenderv1.ExtenderBindingResult
+}
+
+type Simulator interface {
+	// Simulate returns where the pod would be placed without making any change
+	Simulate(ctx context.Context, args SimulateArgs) *SimulateResult
+}
+
+// SimulateArgs represents the arguments of a dry-run placement
+type SimulateArgs struct {
+	// Pod being simulated
+	Pod *corev1.Pod `json:"pod"`
+	// NodeNames restricts the candidate nodes, all nodes are candidates if it's empty
+	NodeNames []string `json:"nodeNames,omitempty"`
+}
+
+// SimulateResult represents the result of a dry-run placement
+type SimulateResult struct {
+	// Node is the chosen node, it's empty if no node fits the pod
+	Node string `json:"node,omitempty"`
+	// Devices are the GPU device indexes of each GPU container
+	Devices map[string][]int `json:"devices,omitempty"`
+	// FailedNodes maps node name to the reason why it's not chosen
+	FailedNodes extenderv1.FailedNodesMap `json:"failedNodes,omitempty"`
+	// Error message indicating failure
+	Error string `json:"error,omitempty"`
 }
@@ -0,0 +1,289 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/klog"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/audit"
+	"tkestack.io/gpu-admission/pkg/metrics"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// snapshot is the nodes and pods the requests are replayed against
+type snapshot struct {
+	nodes []*corev1.Node
+	pods  []*corev1.Pod
+}
+
+// request is a filter request to replay, recorded is nil if the decision isn't known
+type request struct {
+	pod      *corev1.Pod
+	nodes    []corev1.Node
+	recorded *decision
+}
+
+func readFile(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// loadSnapshot reads a list of nodes and pods, or a single one of them
+func loadSnapshot(path string) (*snapshot, error) {
+	data, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &snapshot{}
+	if err := s.add(data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *snapshot) add(data []byte) error {
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, nil)
+	if err != nil {
+		return err
+	}
+	switch obj := obj.(type) {
+	case *corev1.List:
+		for _, item := range obj.Items {
+			if err := s.add(item.Raw); err != nil {
+				return err
+			}
+		}
+	case *corev1.NodeList:
+		for i := range obj.Items {
+			s.nodes = append(s.nodes, &obj.Items[i])
+		}
+	case *corev1.PodList:
+		for i := range obj.Items {
+			s.pods = append(s.pods, &obj.Items[i])
+		}
+	case *corev1.Node:
+		s.nodes = append(s.nodes, obj)
+	case *corev1.Pod:
+		s.pods = append(s.pods, obj)
+	default:
+		klog.Warningf("Ignore %s in snapshot", obj.GetObjectKind().GroupVersionKind().Kind)
+	}
+	return nil
+}
+
+func (s *snapshot) getNode(name string) *corev1.Node {
+	for _, node := range s.nodes {
+		if node.Name == name {
+			return node
+		}
+	}
+	return nil
+}
+
+func (s *snapshot) getPod(namespace, name string) *corev1.Pod {
+	for _, pod := range s.pods {
+		if pod.Namespace == namespace && pod.Name == name {
+			return pod
+		}
+	}
+	return nil
+}
+
+// nodesNamed returns nodes of the snapshot with given names, or all nodes if no name is
+// given. Nodes missing from the snapshot are ignored.
+func (s *snapshot) nodesNamed(names []string) []corev1.Node {
+	var nodes []corev1.Node
+	if len(names) == 0 {
+		for _, node := range s.nodes {
+			nodes = append(nodes, *node)
+		}
+		return nodes
+	}
+	for _, name := range names {
+		node := s.getNode(name)
+		if node == nil {
+			klog.Warningf("Node %s is not in snapshot", name)
+			continue
+		}
+		nodes = append(nodes, *node)
+	}
+	return nodes
+}
+
+func readRequestFile(s *snapshot, path string) ([]*request, error) {
+	data, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return readRequests(s, bytes.NewReader(data))
+}
+
+// readRequests reads a stream of audit records and ExtenderArgs, which are told apart
+// by their fields. Requests of pods without GPU request are ignored as they are not
+// filtered by us.
+func readRequests(s *snapshot, r io.Reader) ([]*request, error) {
+	var requests []*request
+	decoder := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return nil, err
+		}
+
+		var req *request
+		switch {
+		case fields["result"] != nil:
+			var record audit.Record
+			if err := json.Unmarshal(raw, &record); err != nil {
+				return nil, fmt.Errorf("invalid audit record: %v", err)
+			}
+			req = s.requestOfRecord(&record)
+		case fields["Pod"] != nil:
+			var args extenderv1.ExtenderArgs
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return nil, fmt.Errorf("invalid ExtenderArgs: %v", err)
+			}
+			if args.Pod == nil {
+				return nil, fmt.Errorf("ExtenderArgs without pod")
+			}
+			req = s.requestOfArgs(&args)
+		default:
+			return nil, fmt.Errorf("neither an audit record nor ExtenderArgs: %.100s", raw)
+		}
+		if !util.IsGPURequiredPod(req.pod) {
+			continue
+		}
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
+
+// requestOfRecord returns the request of an audit record. The pod is read from the
+// snapshot, or made up of the GPU requests in the record if it's not there.
+func (s *snapshot) requestOfRecord(record *audit.Record) *request {
+	pod := s.getPod(record.Pod.Namespace, record.Pod.Name)
+	if pod == nil {
+		pod = podOfRecord(record)
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	addName := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, candidate := range record.Candidates {
+		addName(candidate.Name)
+	}
+	for name := range record.FailedNodes {
+		addName(name)
+	}
+	addName(record.Node)
+
+	recorded := &decision{}
+	switch record.Result {
+	case metrics.ResultError:
+		recorded.err = record.Error
+	case metrics.ResultPredicated, metrics.ResultDeferred:
+		recorded.node = record.Node
+		recorded.devices = record.Devices
+	}
+	return &request{
+		pod:      resetPod(pod),
+		nodes:    s.nodesNamed(names),
+		recorded: recorded,
+	}
+}
+
+// requestOfArgs returns the request of ExtenderArgs, whose decision isn't known
+func (s *snapshot) requestOfArgs(args *extenderv1.ExtenderArgs) *request {
+	req := &request{pod: resetPod(args.Pod)}
+	switch {
+	case args.Nodes != nil:
+		req.nodes = args.Nodes.Items
+	case args.NodeNames != nil:
+		req.nodes = s.nodesNamed(*args.NodeNames)
+	}
+	return req
+}
+
+func podOfRecord(record *audit.Record) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   record.Pod.Namespace,
+			Name:        record.Pod.Name,
+			UID:         k8stypes.UID(record.Pod.UID),
+			Annotations: make(map[string]string),
+		},
+	}
+	if record.PodGroup != "" {
+		pod.Annotations[util.PodGroupAnnotation] = record.PodGroup
+	}
+	for _, c := range record.Containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+			Name: c.Name,
+			Resources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{
+					util.VCoreAnnotation:   *resource.NewQuantity(int64(c.Cores), resource.DecimalSI),
+					util.VMemoryAnnotation: *resource.NewQuantity(int64(c.Memory), resource.DecimalSI),
+				},
+			},
+		})
+	}
+	return pod
+}
+
+// resetPod returns a copy of pod before it was scheduled
+func resetPod(pod *corev1.Pod) *corev1.Pod {
+	pod = pod.DeepCopy()
+	pod.ResourceVersion = ""
+	pod.Spec.NodeName = ""
+	pod.Status = corev1.PodStatus{Phase: corev1.PodPending}
+	for k := range pod.Annotations {
+		if strings.Contains(k, util.GPUAssigned) ||
+			strings.Contains(k, util.PredicateTimeAnnotation) ||
+			strings.Contains(k, util.PredicateGPUIndexPrefix) ||
+			strings.Contains(k, util.PredicateNode) {
+			delete(pod.Annotations, k)
+		}
+	}
+	return pod
+}
@@ -0,0 +1,338 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package replay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/audit"
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/predicate"
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+const usage = `Replay recorded filter requests against a cluster snapshot, and report the decisions
+which differ from the recorded ones.
+
+Usage:
+  gpu-admission replay --snapshot <file> [flags] <file>...
+
+Each file holds audit records written with --audit-log-path, or ExtenderArgs bodies sent by
+kube-scheduler, "-" reads the standard input. The snapshot is a list of nodes and pods taken
+before the requests, e.g. the output of "kubectl get nodes,pods --all-namespaces -o json".
+It exits 1 if any decision differs.
+
+Flags:
+`
+
+// syncTimeout is how long the filter waits for its cache to observe the snapshot or a
+// placement
+const syncTimeout = time.Minute
+
+type options struct {
+	snapshot string
+	// default overcommit ratios of nodes without labels
+	coreOvercommitRatio   float64
+	memoryOvercommitRatio float64
+	reservationConfig     string
+	parallelism           int
+	percentageOfNodes     int
+	out                   io.Writer
+}
+
+// Run executes the replay subcommand with given arguments and returns the exit code
+func Run(args []string) int {
+	opts := &options{out: os.Stdout}
+	fs := pflag.NewFlagSet("replay", pflag.ContinueOnError)
+	fs.StringVar(&opts.snapshot, "snapshot", "",
+		"Path to a JSON or YAML list of nodes and pods the requests are replayed against")
+	fs.Float64Var(&opts.coreOvercommitRatio, "core-overcommit-ratio", 1,
+		"The ratio from 1 to 10 GPU cores of each device are scaled by, if node is not labeled with "+
+			util.CoreOvercommitRatioLabel)
+	fs.Float64Var(&opts.memoryOvercommitRatio, "memory-overcommit-ratio", 1,
+		"The ratio from 1 to 10 GPU memory of each device is scaled by, if node is not labeled with "+
+			util.MemoryOvercommitRatioLabel)
+	fs.StringVar(&opts.reservationConfig, "reservation-config", "",
+		"Path to a JSON file declaring GPU devices reserved for system workloads")
+	fs.IntVar(&opts.parallelism, "parallelism", predicate.DefaultParallelism,
+		"The number of workers building and evaluating candidate nodes of a filter request")
+	fs.IntVar(&opts.percentageOfNodes, "percentage-of-nodes-to-score", 100,
+		"The percentage of nodes to find feasible before the search of a filter request stops, "+
+			"0 means an adaptive percentage depending on the cluster size")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if opts.snapshot == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if opts.parallelism < 1 {
+		fmt.Fprintf(os.Stderr, "invalid --parallelism %d, it must be at least 1\n", opts.parallelism)
+		return 2
+	}
+	if err := device.ValidateOvercommitRatio(opts.coreOvercommitRatio); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --core-overcommit-ratio: %v\n", err)
+		return 2
+	}
+	if err := device.ValidateOvercommitRatio(opts.memoryOvercommitRatio); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --memory-overcommit-ratio: %v\n", err)
+		return 2
+	}
+
+	s, err := loadSnapshot(opts.snapshot)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load snapshot: %v\n", err)
+		return 1
+	}
+	var requests []*request
+	for _, path := range fs.Args() {
+		fileRequests, err := readRequestFile(s, path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read requests from %s: %v\n", path, err)
+			return 1
+		}
+		requests = append(requests, fileRequests...)
+	}
+
+	differs, err := replay(opts, s, requests)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if differs > 0 {
+		return 1
+	}
+	return 0
+}
+
+// decision is where a pod is placed, node is empty if no node fits the pod
+type decision struct {
+	node    string
+	devices map[string][]int
+	err     string
+}
+
+func (d *decision) String() string {
+	switch {
+	case d == nil:
+		return "-"
+	case d.err != "":
+		return "error: " + d.err
+	case d.node == "":
+		return "<none>"
+	}
+	containers := make([]string, 0, len(d.devices))
+	for name := range d.devices {
+		containers = append(containers, name)
+	}
+	sort.Strings(containers)
+	placement := make([]string, 0, len(containers))
+	for _, name := range containers {
+		indexes := make([]string, 0, len(d.devices[name]))
+		for _, idx := range d.devices[name] {
+			indexes = append(indexes, strconv.Itoa(idx))
+		}
+		placement = append(placement, name+"="+strings.Join(indexes, ","))
+	}
+	return fmt.Sprintf("%s(%s)", d.node, strings.Join(placement, " "))
+}
+
+// equal tells if both decisions place the pod on the same devices, errors are not
+// compared by their messages
+func (d *decision) equal(other *decision) bool {
+	if d.err != "" || other.err != "" {
+		return d.err != "" && other.err != ""
+	}
+	if d.node != other.node || len(d.devices) != len(other.devices) {
+		return false
+	}
+	for name, indexes := range d.devices {
+		otherIndexes, ok := other.devices[name]
+		if !ok || len(indexes) != len(otherIndexes) {
+			return false
+		}
+		for i := range indexes {
+			if indexes[i] != otherIndexes[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// replay feeds the requests in order through a GPUFilter backed by a fake clientset
+// holding the snapshot, and prints the decisions. The number of decisions which differ
+// from the recorded ones is returned.
+func replay(opts *options, s *snapshot, requests []*request) (int, error) {
+	client, err := newClient(s, requests)
+	if err != nil {
+		return 0, err
+	}
+	nodeInfoOptions := []device.Option{
+		device.WithOvercommitRatio(opts.coreOvercommitRatio, opts.memoryOvercommitRatio),
+	}
+	if opts.reservationConfig != "" {
+		reservations, err := device.LoadReservationConfig(opts.reservationConfig)
+		if err != nil {
+			return 0, fmt.Errorf("failed to load reservation config: %v", err)
+		}
+		nodeInfoOptions = append(nodeInfoOptions, device.WithReservations(reservations))
+	}
+	gpuFilter, err := predicate.NewGPUFilter(client,
+		predicate.WithNodeInfoOptions(nodeInfoOptions...),
+		predicate.WithParallelism(opts.parallelism),
+		predicate.WithPercentageOfNodesToScore(opts.percentageOfNodes))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create filter: %v", err)
+	}
+	defer gpuFilter.Stop()
+	if err := wait.PollImmediate(100*time.Millisecond, syncTimeout, func() (bool, error) {
+		return gpuFilter.Ready() == nil, nil
+	}); err != nil {
+		return 0, fmt.Errorf("filter is not ready: %v", err)
+	}
+
+	var differs int
+	w := tabwriter.NewWriter(opts.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "POD\tRECORDED\tREPLAYED\tDIFF")
+	for _, req := range requests {
+		// the pod may have been predicated with other members of its group meanwhile
+		pod, err := client.CoreV1().Pods(req.pod.Namespace).Get(context.Background(),
+			req.pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return 0, fmt.Errorf("failed to get pod %s/%s: %v", req.pod.Namespace,
+				req.pod.Name, err)
+		}
+		result := gpuFilter.Filter(context.Background(), extenderv1.ExtenderArgs{
+			Pod:   pod,
+			Nodes: &corev1.NodeList{Items: req.nodes},
+		})
+		replayed, err := replayedDecision(gpuFilter, client, pod, result)
+		if err != nil {
+			return 0, err
+		}
+
+		diff := ""
+		if req.recorded != nil && !req.recorded.equal(replayed) {
+			diff = "*"
+			differs++
+		}
+		fmt.Fprintf(w, "%s/%s\t%s\t%s\t%s\n", pod.Namespace, pod.Name, req.recorded,
+			replayed, diff)
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	fmt.Fprintf(opts.out, "%d requests replayed, %d decisions differ\n", len(requests), differs)
+	return differs, nil
+}
+
+// newClient returns a fake clientset holding the snapshot, pods of requests are created
+// unscheduled so they are placed by the replay
+func newClient(s *snapshot, requests []*request) (kubernetes.Interface, error) {
+	client := fake.NewSimpleClientset()
+	for _, node := range s.nodes {
+		if _, err := client.CoreV1().Nodes().Create(context.Background(), node,
+			metav1.CreateOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to create node %s: %v", node.Name, err)
+		}
+	}
+	pods := make(map[string]*corev1.Pod)
+	var keys []string
+	for _, pod := range s.pods {
+		key := podKey(pod)
+		if _, ok := pods[key]; !ok {
+			keys = append(keys, key)
+		}
+		pods[key] = pod
+	}
+	for _, req := range requests {
+		key := podKey(req.pod)
+		if _, ok := pods[key]; !ok {
+			keys = append(keys, key)
+		}
+		pods[key] = req.pod
+	}
+	for _, key := range keys {
+		pod := pods[key]
+		if _, err := client.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod,
+			metav1.CreateOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to create pod %s: %v", key, err)
+		}
+	}
+	return client, nil
+}
+
+// replayedDecision returns the decision of a filter result. The predicated pod is
+// waited for until the filter observes it, so the next request sees its devices in use.
+func replayedDecision(gpuFilter *predicate.GPUFilter, client kubernetes.Interface,
+	pod *corev1.Pod, result *extenderv1.ExtenderFilterResult) (*decision, error) {
+	if result.Error != "" {
+		return &decision{err: result.Error}, nil
+	}
+	if result.Nodes == nil || len(result.Nodes.Items) == 0 {
+		return &decision{}, nil
+	}
+	node := &result.Nodes.Items[0]
+	predicated, err := client.CoreV1().Pods(pod.Namespace).Get(context.Background(), pod.Name,
+		metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod %s/%s: %v", pod.Namespace, pod.Name, err)
+	}
+	if err := wait.PollImmediate(10*time.Millisecond, syncTimeout, func() (bool, error) {
+		pods, err := gpuFilter.ListPodsOnNode(node)
+		if err != nil {
+			return false, err
+		}
+		for _, p := range pods {
+			if p.UID == predicated.UID && p.Annotations[util.PredicateNode] == node.Name {
+				return true, nil
+			}
+		}
+		return false, nil
+	}); err != nil {
+		return nil, fmt.Errorf("placement of pod %s/%s is not observed: %v", pod.Namespace,
+			pod.Name, err)
+	}
+	record := audit.NewRecord(predicated)
+	record.SetPlacement(node.Name, predicated)
+	return &decision{node: node.Name, devices: record.Devices}, nil
+}
+
+func podKey(pod *corev1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
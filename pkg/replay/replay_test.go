@@ -0,0 +1,154 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/audit"
+	"tkestack.io/gpu-admission/pkg/metrics"
+	utiltesting "tkestack.io/gpu-admission/pkg/util/testing"
+)
+
+// the snapshot has 2 nodes of 2 devices, device 1 of testnode1 is half used by a running
+// pod, and pod-1 had been bound to testnode0 when it's taken
+const snapshotJSON = `{
+  "apiVersion": "v1",
+  "kind": "List",
+  "items": [
+    {"apiVersion": "v1", "kind": "Node", "metadata": {"name": "testnode0"},
+     "status": {"capacity": {"tencent.com/vcuda-core": "200", "tencent.com/vcuda-memory": "8"}}},
+    {"apiVersion": "v1", "kind": "Node", "metadata": {"name": "testnode1"},
+     "status": {"capacity": {"tencent.com/vcuda-core": "200", "tencent.com/vcuda-memory": "8"}}},
+    {"apiVersion": "v1", "kind": "Pod",
+     "metadata": {"namespace": "test-ns", "name": "running", "uid": "running",
+       "annotations": {"tencent.com/predicate-node": "testnode1", "tencent.com/predicate-gpu-idx-0": "1"}},
+     "spec": {"nodeName": "testnode1", "containers": [{"name": "container-0",
+       "resources": {"limits": {"tencent.com/vcuda-core": "50", "tencent.com/vcuda-memory": "1"}}}]},
+     "status": {"phase": "Running"}},
+    {"apiVersion": "v1", "kind": "Pod",
+     "metadata": {"namespace": "test-ns", "name": "pod-1", "uid": "pod-1",
+       "annotations": {"tencent.com/predicate-node": "testnode0", "tencent.com/predicate-gpu-idx-0": "0"}},
+     "spec": {"nodeName": "testnode0", "containers": [{"name": "container-0",
+       "resources": {"limits": {"tencent.com/vcuda-core": "50", "tencent.com/vcuda-memory": "1"}}}]},
+     "status": {"phase": "Running"}}
+  ]
+}`
+
+const argsJSON = `{"Pod": {"metadata": {"namespace": "test-ns", "name": "pod-2", "uid": "pod-2"},
+  "spec": {"containers": [{"name": "container-0",
+    "resources": {"limits": {"tencent.com/vcuda-core": "100", "tencent.com/vcuda-memory": "4"}}}]}},
+  "NodeNames": ["testnode0"]}`
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	snapshotPath := filepath.Join(dir, "snapshot.json")
+	if err := ioutil.WriteFile(snapshotPath, []byte(snapshotJSON), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := loadSnapshot(snapshotPath)
+	if err != nil {
+		t.Fatalf("failed to load snapshot: %v", err)
+	}
+	if len(s.nodes) != 2 || len(s.pods) != 2 {
+		t.Fatalf("expect 2 nodes and 2 pods, got %d nodes and %d pods", len(s.nodes), len(s.pods))
+	}
+
+	newRecord := func(name, node string, index int) *audit.Record {
+		return &audit.Record{
+			Pod:        audit.Pod{Namespace: "test-ns", Name: name, UID: name},
+			Containers: []audit.Container{{Name: "container-0", Cores: 50, Memory: 1}},
+			Candidates: []audit.Candidate{{Name: "testnode0"}, {Name: "testnode1"}},
+			Node:       node,
+			Devices:    map[string][]int{"container-0": {index}},
+			Result:     metrics.ResultPredicated,
+		}
+	}
+	var input bytes.Buffer
+	encoder := json.NewEncoder(&input)
+	// pod-0 isn't in the snapshot, it's made up of the record and placed as recorded
+	encoder.Encode(newRecord("pod-0", "testnode1", 1))
+	// pod-1 is reset, testnode1 is preferred as it's partially used
+	encoder.Encode(newRecord("pod-1", "testnode0", 0))
+	input.WriteString(argsJSON)
+	requests, err := readRequests(s, &input)
+	if err != nil {
+		t.Fatalf("failed to read requests: %v", err)
+	}
+	if len(requests) != 3 {
+		t.Fatalf("expect 3 requests, got %d", len(requests))
+	}
+	if requests[1].pod.Spec.NodeName != "" || len(requests[1].pod.Annotations) != 0 {
+		t.Fatalf("pod to replay should be reset: %+v", requests[1].pod)
+	}
+
+	var out bytes.Buffer
+	differs, err := replay(&options{out: &out, coreOvercommitRatio: 1, memoryOvercommitRatio: 1,
+		parallelism: 1, percentageOfNodes: 100}, s, requests)
+	if err != nil {
+		t.Fatalf("failed to replay: %v", err)
+	}
+	if differs != 1 {
+		t.Fatalf("expect 1 decision differs, got %d:\n%s", differs, out.String())
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	expected := [][]string{
+		{"test-ns/pod-0", "testnode1(container-0=1)", "testnode1(container-0=1)"},
+		{"test-ns/pod-1", "testnode0(container-0=0)", "testnode1(container-0=0)", "*"},
+		{"test-ns/pod-2", "-", "testnode0(container-0=0)"},
+	}
+	if len(lines) != len(expected)+2 {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+	for i, fields := range expected {
+		if got := strings.Fields(lines[i+1]); strings.Join(got, " ") != strings.Join(fields, " ") {
+			t.Errorf("expect %v, got %v", fields, got)
+		}
+	}
+}
+
+func TestReadRequestsInvalid(t *testing.T) {
+	if _, err := readRequests(&snapshot{}, strings.NewReader(`{"foo": 1}`)); err == nil {
+		t.Fatalf("expect an error for unknown request")
+	}
+}
+
+func TestReplayedDecisionPodGone(t *testing.T) {
+	pod := utiltesting.NewPod("test-ns", "pod-0")
+	result := &extenderv1.ExtenderFilterResult{
+		Nodes: &corev1.NodeList{Items: []corev1.Node{*utiltesting.NewNode("testnode0", 2, 8)}},
+	}
+	// the pod is deleted after it's placed
+	_, err := replayedDecision(nil, fake.NewSimpleClientset(), pod, result)
+	if err == nil || !strings.Contains(err.Error(), "test-ns/pod-0") {
+		t.Fatalf("expect an error of getting pod test-ns/pod-0, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package route
+
+import (
+	"context"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/julienschmidt/httprouter"
+	"k8s.io/klog"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/leader"
+)
+
+// proxiedHeader marks requests proxied by a standby, they are not proxied again
+const proxiedHeader = "X-Gpu-Admission-Proxied"
+
+// Leadership tells which instance serves filter requests
+type Leadership interface {
+	// Serve tells if this instance is the leader. If it is, the request is served with
+	// the returned context, which is cancelled once the leadership is lost, and done
+	// is called after the request is finished.
+	Serve(ctx context.Context) (reqCtx context.Context, done func(), ok bool)
+	// Leader returns the address of the leader, it's empty if unknown
+	Leader() string
+}
+
+// Proxy tells how standbys reach the leader
+type Proxy struct {
+	// Scheme is http or https
+	Scheme string
+	// Transport carries the credentials of standbys, http.DefaultTransport is used if
+	// it's nil
+	Transport http.RoundTripper
+}
+
+// LeaderOnly serves requests by h on the leader. A standby proxies them to the leader
+// in the way given by proxy, or responds a retryable error by writeError if the leader
+// is unknown. Requests are proxied by plain HTTP if proxy is nil.
+func LeaderOnly(leadership Leadership, proxy *Proxy, h httprouter.Handle,
+	writeError func(w http.ResponseWriter, err error)) httprouter.Handle {
+	if proxy == nil {
+		proxy = &Proxy{Scheme: "http"}
+	}
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if ctx, done, ok := leadership.Serve(r.Context()); ok {
+			defer done()
+			h(w, r.WithContext(ctx), p)
+			return
+		}
+		address := leadership.Leader()
+		if address == "" || r.Header.Get(proxiedHeader) != "" {
+			writeError(w, leader.ErrNotLeader)
+			return
+		}
+		klog.V(4).Infof("proxy %s to leader %s", r.URL.Path, address)
+		reverseProxy := httputil.NewSingleHostReverseProxy(
+			&url.URL{Scheme: proxy.Scheme, Host: address})
+		reverseProxy.Transport = proxy.Transport
+		reverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			klog.Infof("failed to proxy %s to leader %s: %v", r.URL.Path, address, err)
+			writeError(w, err)
+		}
+		r.Header.Set(proxiedHeader, "true")
+		reverseProxy.ServeHTTP(w, r)
+	}
+}
+
+// writeFilterError responds err as the result of filter requests
+func writeFilterError(w http.ResponseWriter, err error) {
+	writeJSON(w, &extenderv1.ExtenderFilterResult{Error: err.Error()})
+}
+
+// writeBindError responds err as the result of bind requests
+func writeBindError(w http.ResponseWriter, err error) {
+	writeJSON(w, &extenderv1.ExtenderBindingResult{Error: err.Error()})
+}
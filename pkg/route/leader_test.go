@@ -0,0 +1,195 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+
+	"tkestack.io/gpu-admission/pkg/leader"
+)
+
+// fakeLeadership is a standby of given leader
+type fakeLeadership struct {
+	leader string
+}
+
+func (l *fakeLeadership) Serve(ctx context.Context) (context.Context, func(), bool) {
+	return nil, nil, false
+}
+
+func (l *fakeLeadership) Leader() string {
+	return l.leader
+}
+
+// blockingPredicate blocks filter requests until they are cancelled and released
+type blockingPredicate struct {
+	started   chan struct{}
+	cancelled chan struct{}
+	release   chan struct{}
+}
+
+func (p *blockingPredicate) Name() string {
+	return "blocking"
+}
+
+func (p *blockingPredicate) Filter(ctx context.Context,
+	args extenderv1.ExtenderArgs) *extenderv1.ExtenderFilterResult {
+	close(p.started)
+	<-ctx.Done()
+	close(p.cancelled)
+	<-p.release
+	return &extenderv1.ExtenderFilterResult{Error: ctx.Err().Error()}
+}
+
+type fakeBinder struct{}
+
+func (b *fakeBinder) Bind(ctx context.Context,
+	args extenderv1.ExtenderBindingArgs) *extenderv1.ExtenderBindingResult {
+	return &extenderv1.ExtenderBindingResult{}
+}
+
+func post(router http.Handler, target, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+	return w
+}
+
+func TestLeaderOnlyStandby(t *testing.T) {
+	router := httprouter.New()
+	leadership := &fakeLeadership{}
+	AddPredicate(router, &blockingPredicate{}, leadership, nil)
+	AddBind(router, &fakeBinder{}, leadership, nil)
+
+	w := post(router, predicatesPrefix, "{}")
+	var filterResult map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &filterResult); err != nil {
+		t.Fatalf("failed to decode filter result %s: %v", w.Body.String(), err)
+	}
+	if _, ok := filterResult["FailedNodes"]; !ok ||
+		filterResult["Error"] != leader.ErrNotLeader.Error() {
+		t.Errorf("standby should respond a filter result with retryable error, got %s",
+			w.Body.String())
+	}
+
+	w = post(router, bindPath, "{}")
+	var bindResult map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &bindResult); err != nil {
+		t.Fatalf("failed to decode bind result %s: %v", w.Body.String(), err)
+	}
+	if len(bindResult) != 1 || bindResult["Error"] != leader.ErrNotLeader.Error() {
+		t.Errorf("standby should respond a binding result with retryable error, got %s",
+			w.Body.String())
+	}
+}
+
+func TestLeaderOnlyProxy(t *testing.T) {
+	leaderRouter := httprouter.New()
+	AddBind(leaderRouter, &fakeBinder{}, nil, nil)
+	leaderServer := httptest.NewServer(leaderRouter)
+	defer leaderServer.Close()
+
+	router := httprouter.New()
+	AddBind(router, &fakeBinder{},
+		&fakeLeadership{leader: strings.TrimPrefix(leaderServer.URL, "http://")}, nil)
+	w := post(router, bindPath, "{}")
+	var bindResult extenderv1.ExtenderBindingResult
+	if err := json.Unmarshal(w.Body.Bytes(), &bindResult); err != nil || bindResult.Error != "" {
+		t.Errorf("standby should proxy the request to the leader, got %s", w.Body.String())
+	}
+}
+
+func TestLeaderOnlyHandoff(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	elector, err := leader.NewElector(client, leader.Config{
+		Namespace:     "kube-system",
+		Name:          "gpu-admission",
+		Identity:      "10.0.0.1:3456",
+		LeaseDuration: 5 * time.Second,
+		RenewDeadline: time.Second,
+		RetryPeriod:   100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create elector: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		elector.Run(ctx)
+		close(stopped)
+	}()
+	err = wait.PollImmediate(50*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return elector.IsLeader(), nil
+	})
+	if err != nil {
+		t.Fatalf("elector should lead")
+	}
+
+	predicate := &blockingPredicate{
+		started:   make(chan struct{}),
+		cancelled: make(chan struct{}),
+		release:   make(chan struct{}),
+	}
+	router := httprouter.New()
+	AddPredicate(router, predicate, elector, nil)
+	served := make(chan *httptest.ResponseRecorder)
+	go func() {
+		served <- post(router, predicatesPrefix, "{}")
+	}()
+	<-predicate.started
+
+	holder := func() string {
+		lease, err := client.CoordinationV1().Leases("kube-system").Get(context.Background(),
+			"gpu-admission", metav1.GetOptions{})
+		if err != nil || lease.Spec.HolderIdentity == nil {
+			return ""
+		}
+		return *lease.Spec.HolderIdentity
+	}
+	// the in-flight request is cancelled when the leader stops, and the Lease is
+	// held until the request finishes
+	cancel()
+	select {
+	case <-predicate.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("in-flight request should be cancelled when the leader stops")
+	}
+	if holder() != "10.0.0.1:3456" {
+		t.Fatalf("Lease should not be released with requests in flight")
+	}
+	close(predicate.release)
+	w := <-served
+	if !strings.Contains(w.Body.String(), context.Canceled.Error()) {
+		t.Errorf("request should fail with cancellation, got %s", w.Body.String())
+	}
+	<-stopped
+	if holder() != "" {
+		t.Errorf("Lease should be released after in-flight requests finish, held by %s",
+			holder())
+	}
+}
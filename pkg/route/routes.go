@@ -22,11 +22,19 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog"
 	extenderv1 "k8s.io/kube-scheduler/extender/v1"
 
+	"tkestack.io/gpu-admission/pkg/fragmentation"
+	"tkestack.io/gpu-admission/pkg/inventory"
+	"tkestack.io/gpu-admission/pkg/metrics"
 	"tkestack.io/gpu-admission/pkg/predicate"
 	"tkestack.io/gpu-admission/pkg/version"
 )
@@ -37,19 +45,47 @@ const (
 	apiPrefix   = "/scheduler"
 	// predication router path
 	predicatesPrefix = apiPrefix + "/predicates"
+	// binding router path
+	bindPath = apiPrefix + "/bind"
+	// dry-run placement router path
+	simulatePath = apiPrefix + "/simulate"
+	// GPU inventory router path
+	nodesPath = "/api/v1/nodes"
+	// fragmentation analysis router path
+	fragmentationPath   = "/api/v1/fragmentation"
+	defragmentationPath = "/api/v1/defragmentation"
+	// prometheus metrics router path
+	metricsPath = "/metrics"
+	// liveness and readiness router path
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
 )
 
-func checkBody(w http.ResponseWriter, r *http.Request) {
+// HealthPaths are the paths of liveness and readiness endpoints, which are probed
+// without credentials
+var HealthPaths = []string{healthzPath, readyzPath}
+
+// ReadinessChecker tells if a component is ready to serve
+type ReadinessChecker interface {
+	// Ready returns the reason why it's not ready, or nil
+	Ready() error
+}
+
+// checkBody responds 400 and returns false if the request has no body
+func checkBody(w http.ResponseWriter, r *http.Request) bool {
 	if r.Body == nil {
 		http.Error(w, "Please send a request body", 400)
-		return
+		return false
 	}
+	return true
 }
 
 // PredicateRoute sets router table for predication
 func PredicateRoute(predicate predicate.Predicate) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		checkBody(w, r)
+		if !checkBody(w, r) {
+			return
+		}
 
 		var buf bytes.Buffer
 		body := io.TeeReader(r.Body, &buf)
@@ -64,7 +100,7 @@ func PredicateRoute(predicate predicate.Predicate) httprouter.Handle {
 				Error:       err.Error(),
 			}
 		} else {
-			extenderFilterResult = predicate.Filter(extenderArgs)
+			extenderFilterResult = predicate.Filter(r.Context(), extenderArgs)
 			klog.V(4).Infof("%s: ExtenderArgs = %+v", predicate.Name(), extenderArgs)
 		}
 
@@ -84,6 +120,160 @@ func PredicateRoute(predicate predicate.Predicate) httprouter.Handle {
 	}
 }
 
+// BindRoute sets router table for binding
+func BindRoute(binder predicate.Binder) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		if !checkBody(w, r) {
+			return
+		}
+
+		var bindingArgs extenderv1.ExtenderBindingArgs
+		var bindingResult *extenderv1.ExtenderBindingResult
+
+		if err := json.NewDecoder(r.Body).Decode(&bindingArgs); err != nil {
+			bindingResult = &extenderv1.ExtenderBindingResult{
+				Error: err.Error(),
+			}
+		} else {
+			bindingResult = binder.Bind(r.Context(), bindingArgs)
+			klog.V(4).Infof("ExtenderBindingArgs = %+v", bindingArgs)
+		}
+
+		if resultBody, err := json.Marshal(bindingResult); err != nil {
+			klog.Errorf("Failed to marshal bindingResult: %+v, %+v",
+				err, bindingResult)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+		} else {
+			klog.V(4).Infof("bindingResult = %s", string(resultBody))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(resultBody)
+		}
+	}
+}
+
+// SimulateRoute sets router table for dry-run placement
+func SimulateRoute(simulator predicate.Simulator) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		if !checkBody(w, r) {
+			return
+		}
+
+		var simulateArgs predicate.SimulateArgs
+		var simulateResult *predicate.SimulateResult
+
+		if err := json.NewDecoder(r.Body).Decode(&simulateArgs); err != nil {
+			simulateResult = &predicate.SimulateResult{
+				Error: err.Error(),
+			}
+		} else {
+			simulateResult = simulator.Simulate(r.Context(), simulateArgs)
+		}
+
+		if resultBody, err := json.Marshal(simulateResult); err != nil {
+			klog.Errorf("Failed to marshal simulateResult: %+v, %+v",
+				err, simulateResult)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+		} else {
+			klog.V(4).Infof("simulateResult = %s", string(resultBody))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(resultBody)
+		}
+	}
+}
+
+// NodeListRoute returns the GPU inventory of nodes, which can be filtered by
+// labelSelector query parameter
+func NodeListRoute(lister inventory.Lister) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		nodeInfos, err := lister.ListNodeInfos(selector)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, inventory.NewNodeList(nodeInfos))
+	}
+}
+
+// NodeRoute returns the GPU inventory of given node
+func NodeRoute(lister inventory.Lister) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		nodeInfo, err := lister.GetNodeInfo(p.ByName("name"))
+		if err != nil {
+			status := http.StatusInternalServerError
+			if apierrors.IsNotFound(err) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		writeJSON(w, inventory.NewNode(nodeInfo))
+	}
+}
+
+// FragmentationRoute returns the fragmentation metrics of nodes, which can be
+// filtered by labelSelector query parameter
+func FragmentationRoute(lister inventory.Lister) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		nodeInfos, err := lister.ListNodeInfos(selector)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, fragmentation.Analyze(nodeInfos))
+	}
+}
+
+// DefragmentationRoute returns the pod moves which would free the number of whole
+// devices given by devices query parameter
+func DefragmentationRoute(lister inventory.Lister) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		devices, err := strconv.Atoi(r.URL.Query().Get("devices"))
+		if err != nil || devices <= 0 {
+			http.Error(w, "devices should be a positive integer", http.StatusBadRequest)
+			return
+		}
+		nodeInfos, err := lister.ListNodeInfos(selector)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, fragmentation.Recommend(nodeInfos, devices))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, obj interface{}) {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		klog.Errorf("Failed to marshal %+v: %+v", obj, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
 // VersionRoute returns the version of router in response
 func VersionRoute(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	fmt.Fprint(w, fmt.Sprint(version.Get()))
@@ -102,7 +292,83 @@ func DebugLogging(h httprouter.Handle, path string) httprouter.Handle {
 	}
 }
 
-func AddPredicate(router *httprouter.Router, predicate predicate.Predicate) {
+// AddPredicate registers the filter endpoint, which is served only by the leader if
+// leadership is not nil, standbys proxy requests to the leader by proxy
+func AddPredicate(router *httprouter.Router, predicate predicate.Predicate,
+	leadership Leadership, proxy *Proxy) {
 	path := predicatesPrefix
-	router.POST(path, DebugLogging(PredicateRoute(predicate), path))
+	handle := PredicateRoute(predicate)
+	if leadership != nil {
+		handle = LeaderOnly(leadership, proxy, handle, writeFilterError)
+	}
+	router.POST(path, DebugLogging(handle, path))
+}
+
+// AddBind registers the bind endpoint, which is served only by the leader if
+// leadership is not nil, standbys proxy requests to the leader by proxy
+func AddBind(router *httprouter.Router, binder predicate.Binder,
+	leadership Leadership, proxy *Proxy) {
+	path := bindPath
+	handle := BindRoute(binder)
+	if leadership != nil {
+		handle = LeaderOnly(leadership, proxy, handle, writeBindError)
+	}
+	router.POST(path, DebugLogging(handle, path))
+}
+
+func AddSimulate(router *httprouter.Router, simulator predicate.Simulator) {
+	path := simulatePath
+	router.POST(path, DebugLogging(SimulateRoute(simulator), path))
+}
+
+func AddInventory(router *httprouter.Router, lister inventory.Lister) {
+	router.GET(nodesPath, DebugLogging(NodeListRoute(lister), nodesPath))
+	path := nodesPath + "/:name"
+	router.GET(path, DebugLogging(NodeRoute(lister), path))
+}
+
+func AddFragmentation(router *httprouter.Router, lister inventory.Lister) {
+	router.GET(fragmentationPath,
+		DebugLogging(FragmentationRoute(lister), fragmentationPath))
+	router.GET(defragmentationPath,
+		DebugLogging(DefragmentationRoute(lister), defragmentationPath))
+}
+
+// AddMetrics registers the metrics of filter and the GPU allocation state of nodes
+// listed by lister, and serves them in prometheus format
+func AddMetrics(router *httprouter.Router, lister inventory.Lister) {
+	metrics.Register()
+	metrics.RegisterClusterCollector(lister)
+	router.Handler(http.MethodGet, metricsPath, legacyregistry.Handler())
+}
+
+// HealthzRoute tells the process is alive
+func HealthzRoute(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+// ReadyzRoute tells if all checkers are ready, it responds 503 with the reasons if
+// any of them is not
+func ReadyzRoute(checkers ...ReadinessChecker) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		var reasons []string
+		for _, checker := range checkers {
+			if err := checker.Ready(); err != nil {
+				reasons = append(reasons, err.Error())
+			}
+		}
+		if len(reasons) > 0 {
+			http.Error(w, strings.Join(reasons, "\n"), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}
+}
+
+// AddHealth registers the liveness and readiness endpoints
+func AddHealth(router *httprouter.Router, checkers ...ReadinessChecker) {
+	router.GET(healthzPath, HealthzRoute)
+	router.GET(readyzPath, ReadyzRoute(checkers...))
 }
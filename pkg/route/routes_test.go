@@ -0,0 +1,145 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"tkestack.io/gpu-admission/pkg/device"
+	"tkestack.io/gpu-admission/pkg/inventory"
+	utiltesting "tkestack.io/gpu-admission/pkg/util/testing"
+)
+
+// fakeLister lists the allocation state of nodes built from node objects
+type fakeLister struct {
+	nodes []*corev1.Node
+}
+
+func (l *fakeLister) ListNodeInfos(selector labels.Selector) ([]*device.NodeInfo, error) {
+	var nodeInfos []*device.NodeInfo
+	for _, node := range l.nodes {
+		if selector.Matches(labels.Set(node.Labels)) {
+			nodeInfos = append(nodeInfos, device.NewNodeInfo(node, nil))
+		}
+	}
+	return nodeInfos, nil
+}
+
+func (l *fakeLister) GetNodeInfo(name string) (*device.NodeInfo, error) {
+	for _, node := range l.nodes {
+		if node.Name == name {
+			return device.NewNodeInfo(node, nil), nil
+		}
+	}
+	return nil, apierrors.NewNotFound(corev1.Resource("nodes"), name)
+}
+
+func newTestNode(name string, labels map[string]string) *corev1.Node {
+	node := utiltesting.NewNode(name, 2, 8)
+	node.Labels = labels
+	return node
+}
+
+func newInventoryRouter() *httprouter.Router {
+	router := httprouter.New()
+	AddInventory(router, &fakeLister{nodes: []*corev1.Node{
+		newTestNode("testnode1", map[string]string{"pool": "b"}),
+		newTestNode("testnode0", map[string]string{"pool": "a"}),
+	}})
+	return router
+}
+
+func serve(router http.Handler, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestNodeListRoute(t *testing.T) {
+	router := newInventoryRouter()
+
+	w := serve(router, http.MethodGet, nodesPath)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	var list inventory.NodeList
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("invalid node list %s: %v", w.Body.String(), err)
+	}
+	if len(list.Items) != 2 || list.Items[0].Name != "testnode0" ||
+		list.Items[0].DeviceCount != 2 || list.Items[0].AllocatableCores != 200 {
+		t.Fatalf("unexpected node list: %+v", list)
+	}
+
+	w = serve(router, http.MethodGet, nodesPath+"?labelSelector=pool%3Db")
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("invalid node list %s: %v", w.Body.String(), err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "testnode1" {
+		t.Fatalf("nodes should be filtered by label selector: %+v", list)
+	}
+
+	if w := serve(router, http.MethodGet, nodesPath+"?labelSelector=%3D%3D"); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid label selector should be rejected, got %d", w.Code)
+	}
+}
+
+func TestNodeRoute(t *testing.T) {
+	router := newInventoryRouter()
+
+	w := serve(router, http.MethodGet, nodesPath+"/testnode0")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	var node inventory.Node
+	if err := json.Unmarshal(w.Body.Bytes(), &node); err != nil {
+		t.Fatalf("invalid node %s: %v", w.Body.String(), err)
+	}
+	if node.Name != "testnode0" || len(node.Devices) != 2 {
+		t.Fatalf("unexpected node: %+v", node)
+	}
+
+	if w := serve(router, http.MethodGet, nodesPath+"/unknown"); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown node should respond 404, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRoutesWithoutBody(t *testing.T) {
+	// the handlers must not be reached without a body
+	routes := map[string]httprouter.Handle{
+		"predicate": PredicateRoute(nil),
+		"bind":      BindRoute(nil),
+		"simulate":  SimulateRoute(nil),
+	}
+	for name, route := range routes {
+		r := httptest.NewRequest("POST", "/"+name, nil)
+		r.Body = nil
+		w := httptest.NewRecorder()
+		route(w, r, nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s route without body: expect %d, got %d", name, http.StatusBadRequest, w.Code)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package server
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"k8s.io/klog"
+)
+
+// authenticator allows requests with a verified client certificate or a known bearer
+// token, the same credentials kube-scheduler can send to extenders
+type authenticator struct {
+	// clientCert tells if verified client certificates are accepted
+	clientCert bool
+	tokens     [][]byte
+	// alwaysAllowPaths are served without authentication
+	alwaysAllowPaths map[string]bool
+}
+
+// loadTokens reads bearer tokens from file, one per line, empty lines and lines
+// starting with # are ignored
+func loadTokens(file string) ([][]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var tokens [][]byte
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, []byte(line))
+	}
+	return tokens, scanner.Err()
+}
+
+// enabled tells if any credential is required
+func (a *authenticator) enabled() bool {
+	return a.clientCert || len(a.tokens) > 0
+}
+
+// authenticate tells if the request carries a valid credential
+func (a *authenticator) authenticate(r *http.Request) bool {
+	if a.clientCert && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := []byte(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare(token, t) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap rejects the unauthenticated requests to h
+func (a *authenticator) wrap(h http.Handler) http.Handler {
+	if !a.enabled() {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.alwaysAllowPaths[r.URL.Path] && !a.authenticate(r) {
+			klog.V(4).Infof("unauthorized request %s %s from %s", r.Method, r.URL.Path,
+				r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gpu-admission"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
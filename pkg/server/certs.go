@@ -0,0 +1,137 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// certReloader serves the certificate loaded from files, and reloads it when the
+// files are rotated while it runs
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+// newCertReloader loads the certificate and key from files
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the certificate and key again if any of them has been modified
+func (r *certReloader) reload() error {
+	modTimes, err := r.readModTimes()
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	changed := r.cert == nil || modTimes != r.modTimes
+	r.mu.RUnlock()
+	if !changed {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s and key %s: %v", r.certFile,
+			r.keyFile, err)
+	}
+	r.mu.Lock()
+	r.cert, r.modTimes = &cert, modTimes
+	r.mu.Unlock()
+	klog.Infof("loaded certificate %s and key %s", r.certFile, r.keyFile)
+	return nil
+}
+
+func (r *certReloader) readModTimes() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// certReloadInterval is how often the certificate files are checked for rotation
+const certReloadInterval = 10 * time.Second
+
+// run checks the files every interval and reloads them if they are rotated, until
+// stopCh is closed. The last loaded certificate is kept if the rotated files can't be
+// loaded, e.g. the key has been written but the certificate hasn't.
+func (r *certReloader) run(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			if err := r.reload(); err != nil {
+				klog.Errorf("failed to reload certificate, keep serving the last one: %v", err)
+			}
+		}
+	}
+}
+
+// certificate returns the last loaded certificate
+func (r *certReloader) certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// GetCertificate is used as tls.Config.GetCertificate
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.certificate(), nil
+}
+
+// GetClientCertificate is used as tls.Config.GetClientCertificate
+func (r *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.certificate(), nil
+}
+
+// loadCertPool reads PEM encoded certificates from file
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", file)
+	}
+	return pool, nil
+}
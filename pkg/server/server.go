@@ -0,0 +1,171 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"k8s.io/klog"
+)
+
+// Config configures how the endpoints are served
+type Config struct {
+	// Address is the address to listen on
+	Address string
+	// CertFile and KeyFile serve HTTPS if set, they are reloaded after rotation
+	CertFile string
+	KeyFile  string
+	// ClientCAFile verifies client certificates if set, requests with verified
+	// certificates are authenticated
+	ClientCAFile string
+	// LeaderCAFile verifies the serving certificates of other instances which
+	// requests are proxied to, the system roots are used if it's not set
+	LeaderCAFile string
+	// TokenFile holds the accepted bearer tokens, one per line
+	TokenFile string
+	// AlwaysAllowPaths are served without authentication, e.g. health checks
+	AlwaysAllowPaths []string
+}
+
+// Server serves HTTP or HTTPS, and authenticates requests by client certificates or
+// bearer tokens if any of them is configured
+type Server struct {
+	server    *http.Server
+	certs     *certReloader
+	leaderCAs *x509.CertPool
+	// stopCh stops reloading the certificate when closed
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// New returns a Server serving handler
+func New(config Config, handler http.Handler) (*Server, error) {
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("certificate and key files should be given together")
+	}
+	if config.ClientCAFile != "" && config.CertFile == "" {
+		return nil, errors.New("client certificate verification requires serving HTTPS")
+	}
+	if config.LeaderCAFile != "" && config.CertFile == "" {
+		return nil, errors.New("leader certificate verification requires serving HTTPS")
+	}
+
+	s := &Server{stopCh: make(chan struct{})}
+	auth := &authenticator{alwaysAllowPaths: make(map[string]bool)}
+	for _, path := range config.AlwaysAllowPaths {
+		auth.alwaysAllowPaths[path] = true
+	}
+	if config.TokenFile != "" {
+		tokens, err := loadTokens(config.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tokens: %v", err)
+		}
+		if len(tokens) == 0 {
+			return nil, fmt.Errorf("no token found in %s", config.TokenFile)
+		}
+		if config.CertFile == "" {
+			klog.Warningf("bearer tokens are sent in plain text on %s without HTTPS",
+				config.Address)
+		}
+		auth.tokens = tokens
+	}
+
+	s.server = &http.Server{Addr: config.Address}
+	if config.CertFile != "" {
+		certs, err := newCertReloader(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		s.certs = certs
+		s.server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+	}
+	if config.ClientCAFile != "" {
+		clientCAs, err := loadCertPool(config.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client CA: %v", err)
+		}
+		// clients without certificates, e.g. probes of kubelet, are still allowed to
+		// connect, they are authenticated by tokens or allowed paths
+		s.server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		s.server.TLSConfig.ClientCAs = clientCAs
+		auth.clientCert = true
+	}
+	if config.LeaderCAFile != "" {
+		leaderCAs, err := loadCertPool(config.LeaderCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load leader CA: %v", err)
+		}
+		s.leaderCAs = leaderCAs
+	}
+	s.server.Handler = auth.wrap(handler)
+	return s, nil
+}
+
+// TLS tells if the server serves HTTPS
+func (s *Server) TLS() bool {
+	return s.certs != nil
+}
+
+// Scheme returns the URL scheme of the server
+func (s *Server) Scheme() string {
+	if s.TLS() {
+		return "https"
+	}
+	return "http"
+}
+
+// ProxyTransport returns the transport which reaches other instances served the same
+// way, it presents the serving certificate as client certificate and trusts the
+// leader CA if given
+func (s *Server) ProxyTransport() http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if s.TLS() {
+		transport.TLSClientConfig = &tls.Config{
+			MinVersion:           tls.VersionTLS12,
+			RootCAs:              s.leaderCAs,
+			GetClientCertificate: s.certs.GetClientCertificate,
+		}
+	}
+	return transport
+}
+
+// ListenAndServe serves until the server fails or is shut down, http.ErrServerClosed
+// is returned after Shutdown
+func (s *Server) ListenAndServe() error {
+	if s.TLS() {
+		go s.certs.run(certReloadInterval, s.stopCh)
+		// the certificate is given by TLSConfig
+		return s.server.ListenAndServeTLS("", "")
+	}
+	return s.server.ListenAndServe()
+}
+
+// Shutdown stops accepting new connections and waits for the in-flight requests to
+// finish until ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+	return s.server.Shutdown(ctx)
+}
@@ -0,0 +1,275 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate of commonName and its key to dir
+func writeCert(t *testing.T, dir, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err := ioutil.WriteFile(certFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := ioutil.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCert(t, dir, "first")
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("failed to load certificate: %v", err)
+	}
+	commonName := func() string {
+		cert, err := x509.ParseCertificate(r.certificate().Certificate[0])
+		if err != nil {
+			t.Fatalf("failed to parse certificate: %v", err)
+		}
+		return cert.Subject.CommonName
+	}
+	if name := commonName(); name != "first" {
+		t.Fatalf("expect certificate first, got %s", name)
+	}
+
+	// the files are checked periodically rather than on every handshake
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go r.run(10*time.Millisecond, stopCh)
+	waitForCommonName := func(expected string) {
+		var name string
+		for i := 0; i < 100; i++ {
+			if name = commonName(); name == expected {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expect certificate %s, got %s", expected, name)
+	}
+
+	// rotate the files
+	writeCert(t, dir, "second")
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{certFile, keyFile} {
+		os.Chtimes(file, later, later)
+	}
+	waitForCommonName("second")
+
+	// the last certificate is kept if the files are broken
+	ioutil.WriteFile(certFile, []byte("broken"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	time.Sleep(50 * time.Millisecond)
+	if name := commonName(); name != "second" {
+		t.Fatalf("expect certificate second kept, got %s", name)
+	}
+}
+
+func TestProxyTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCert(t, dir, "gpu-admission")
+
+	if _, err := New(Config{LeaderCAFile: certFile}, http.NotFoundHandler()); err == nil {
+		t.Fatalf("leader CA should require serving HTTPS")
+	}
+	// the leader is verified by the leader CA rather than the client CA
+	s, err := New(Config{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile},
+		http.NotFoundHandler())
+	if err != nil {
+		t.Fatalf("failed to new server: %v", err)
+	}
+	if config := s.ProxyTransport().(*http.Transport).TLSClientConfig; config.RootCAs != nil {
+		t.Fatalf("system roots should be trusted without leader CA")
+	}
+	s, err = New(Config{CertFile: certFile, KeyFile: keyFile, LeaderCAFile: certFile},
+		http.NotFoundHandler())
+	if err != nil {
+		t.Fatalf("failed to new server: %v", err)
+	}
+	if config := s.ProxyTransport().(*http.Transport).TLSClientConfig; config.RootCAs == nil {
+		t.Fatalf("leader CA should be trusted")
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tokens")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "tokens")
+	ioutil.WriteFile(tokenFile, []byte("# scheduler\ntoken-0\n\ntoken-1\n"), 0600)
+
+	s, err := New(Config{TokenFile: tokenFile, AlwaysAllowPaths: []string{"/healthz"}},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	if err != nil {
+		t.Fatalf("failed to new server: %v", err)
+	}
+	testCases := []struct {
+		path     string
+		token    string
+		expected int
+	}{
+		{path: "/scheduler/predicates", expected: http.StatusUnauthorized},
+		{path: "/scheduler/predicates", token: "token-1", expected: http.StatusOK},
+		{path: "/scheduler/predicates", token: "token-2", expected: http.StatusUnauthorized},
+		{path: "/healthz", expected: http.StatusOK},
+	}
+	for _, cs := range testCases {
+		r := httptest.NewRequest("POST", cs.path, nil)
+		if cs.token != "" {
+			r.Header.Set("Authorization", "Bearer "+cs.token)
+		}
+		w := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(w, r)
+		if w.Code != cs.expected {
+			t.Errorf("%s with token %q: expect %d, got %d", cs.path, cs.token, cs.expected, w.Code)
+		}
+	}
+}
+
+func TestClientCertAuthentication(t *testing.T) {
+	a := &authenticator{clientCert: true}
+	r := httptest.NewRequest("POST", "/scheduler/predicates", nil)
+	if a.authenticate(r) {
+		t.Fatalf("request without client certificate should not be authenticated")
+	}
+	r.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{&x509.Certificate{}}},
+	}
+	if !a.authenticate(r) {
+		t.Fatalf("request with verified client certificate should be authenticated")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	// find a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	started, finish := make(chan struct{}), make(chan struct{})
+	s, err := New(Config{Address: address},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-finish
+			w.WriteHeader(http.StatusOK)
+		}))
+	if err != nil {
+		t.Fatalf("failed to new server: %v", err)
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.ListenAndServe()
+	}()
+
+	responded := make(chan int, 1)
+	go func() {
+		var (
+			resp *http.Response
+			err  error
+		)
+		for i := 0; i < 50; i++ {
+			if resp, err = http.Post("http://"+address+"/scheduler/predicates", "", nil); err == nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if err != nil {
+			responded <- 0
+			return
+		}
+		resp.Body.Close()
+		responded <- resp.StatusCode
+	}()
+	<-started
+
+	// the in-flight request is finished before Shutdown returns
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- s.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("shutdown returned before the in-flight request finished: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(finish)
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("failed to shut down: %v", err)
+	}
+	if code := <-responded; code != http.StatusOK {
+		t.Fatalf("expect in-flight request responded 200, got %d", code)
+	}
+	if err := <-serveErr; err != http.ErrServerClosed {
+		t.Fatalf("expect server closed, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ * Tencent is pleased to support the open source community by making TKEStack available.
+ *
+ * Copyright (C) 2012-2019 Tencent. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use
+ * this file except in compliance with the License. You may obtain a copy of the
+ * License at
+ *
+ * https://opensource.org/licenses/Apache-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+ * WARRANTIES OF ANY KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations under the License.
+ */
+// Package testing provides fixtures of nodes and pods with GPU resources for tests
+package testing
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	"tkestack.io/gpu-admission/pkg/util"
+)
+
+// NewNode returns a node with given number of GPU devices, memory is the total GPU
+// memory of them
+func NewNode(name string, devices, memory int) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{
+				util.VCoreAnnotation:   resource.MustParse(fmt.Sprintf("%d", devices*util.HundredCore)),
+				util.VMemoryAnnotation: resource.MustParse(fmt.Sprintf("%d", memory)),
+			},
+		},
+	}
+}
+
+// NewContainer returns a container limited to given GPU cores and memory
+func NewContainer(name, cores, memory string) corev1.Container {
+	return corev1.Container{
+		Name: name,
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{
+				util.VCoreAnnotation:   resource.MustParse(cores),
+				util.VMemoryAnnotation: resource.MustParse(memory),
+			},
+		},
+	}
+}
+
+// NewPod returns a pending pod of given containers, its UID is its name
+func NewPod(namespace, name string, containers ...corev1.Container) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			UID:         k8stypes.UID(name),
+			Annotations: make(map[string]string),
+		},
+		Spec: corev1.PodSpec{
+			Containers: containers,
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+		},
+	}
+}
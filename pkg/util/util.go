@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"k8s.io/api/core/v1"
 	apierr "k8s.io/apimachinery/pkg/api/errors"
@@ -33,7 +34,27 @@ const (
 	PredicateGPUIndexPrefix = "tencent.com/predicate-gpu-idx-"
 	PredicateNode           = "tencent.com/predicate-node"
 	GPUAssigned             = "tencent.com/gpu-assigned"
-	HundredCore             = 100
+	// NvidiaGPUResource is the whole-GPU resource advertised by nvidia device plugin,
+	// every unit of it is treated as one exclusive device
+	NvidiaGPUResource = "nvidia.com/gpu"
+	HundredCore       = 100
+	// CoreOvercommitRatioLabel and MemoryOvercommitRatioLabel are node labels which
+	// scale GPU cores and memory of each device on the node
+	CoreOvercommitRatioLabel   = "tencent.com/vcuda-core-overcommit-ratio"
+	MemoryOvercommitRatioLabel = "tencent.com/vcuda-memory-overcommit-ratio"
+	// UnhealthyGPUIndexAnnotation and DrainedGPUIndexAnnotation are node annotations
+	// of comma separated device indexes, like "1,3", which can't be allocated
+	UnhealthyGPUIndexAnnotation = "tencent.com/unhealthy-gpu-idx"
+	DrainedGPUIndexAnnotation   = "tencent.com/drained-gpu-idx"
+	// ReservedGPUCountLabel is a node label of the number of devices reserved for
+	// system workloads
+	ReservedGPUCountLabel = "tencent.com/gpu-reserved-count"
+	// PodGroupAnnotation is the name of pod group which the pod belongs to, pods of a
+	// group are admitted all or nothing
+	PodGroupAnnotation = "tencent.com/pod-group"
+	// PodGroupMinMemberAnnotation is the minimal number of pods of a pod group
+	// which should be admitted together
+	PodGroupMinMemberAnnotation = "tencent.com/pod-group-min-member"
 )
 
 // IsGPURequiredPod tell if the pod is a GPU request pod
@@ -42,9 +63,10 @@ func IsGPURequiredPod(pod *v1.Pod) bool {
 
 	vcore := GetGPUResourceOfPod(pod, VCoreAnnotation)
 	vmemory := GetGPUResourceOfPod(pod, VMemoryAnnotation)
+	wholeGPU := GetGPUResourceOfPod(pod, NvidiaGPUResource)
 
 	// Check if pod request for GPU resource
-	if vcore <= 0 || (vcore < HundredCore && vmemory <= 0) {
+	if wholeGPU <= 0 && (vcore <= 0 || (vcore < HundredCore && vmemory <= 0)) {
 		klog.V(4).Infof("Pod %s in namespace %s does not Request for GPU resource",
 			pod.Name,
 			pod.Namespace)
@@ -60,9 +82,10 @@ func IsGPURequiredContainer(c *v1.Container) bool {
 
 	vcore := GetGPUResourceOfContainer(c, VCoreAnnotation)
 	vmemory := GetGPUResourceOfContainer(c, VMemoryAnnotation)
+	wholeGPU := GetGPUResourceOfContainer(c, NvidiaGPUResource)
 
 	// Check if container request for GPU resource
-	if vcore <= 0 || (vcore < HundredCore && vmemory <= 0) {
+	if wholeGPU <= 0 && (vcore <= 0 || (vcore < HundredCore && vmemory <= 0)) {
 		klog.V(4).Infof("Container %s does not Request for GPU resource", c.Name)
 		return false
 	}
@@ -91,9 +114,25 @@ func GetGPUResourceOfContainer(container *v1.Container, resourceName v1.Resource
 	return count
 }
 
+// GetGPUCoresOfContainer returns the GPU cores requested by given container, a
+// whole-GPU request of nvidia.com/gpu is converted to HundredCore per device
+func GetGPUCoresOfContainer(container *v1.Container) uint {
+	if vcore := GetGPUResourceOfContainer(container, VCoreAnnotation); vcore > 0 {
+		return vcore
+	}
+	return GetGPUResourceOfContainer(container, NvidiaGPUResource) * HundredCore
+}
+
+// IsWholeGPUContainer tell if the container requests whole GPU devices by
+// nvidia.com/gpu instead of vcuda resources
+func IsWholeGPUContainer(container *v1.Container) bool {
+	return GetGPUResourceOfContainer(container, VCoreAnnotation) == 0 &&
+		GetGPUResourceOfContainer(container, NvidiaGPUResource) > 0
+}
+
 // Is the Node has GPU device
 func IsGPUEnabledNode(node *v1.Node) bool {
-	return GetCapacityOfNode(node, VCoreAnnotation) > 0
+	return GetGPUDeviceCountOfNode(node) > 0
 }
 
 // Get the capacity of request resource of the Node
@@ -111,7 +150,8 @@ func GetCapacityOfNode(node *v1.Node, resourceName string) int {
 func GetGPUDeviceCountOfNode(node *v1.Node) int {
 	val, ok := node.Status.Capacity[VCoreAnnotation]
 	if !ok {
-		return 0
+		// node only managed by nvidia device plugin
+		return GetCapacityOfNode(node, NvidiaGPUResource)
 	}
 	return int(val.Value()) / HundredCore
 }
@@ -135,6 +175,76 @@ func GetPredicateIdxOfContainer(pod *v1.Pod, containerIndex int) ([]int, error)
 	return ret, nil
 }
 
+// IsPodOnNode tell if the pod is running on given node, or has been predicated to
+// it but not bound yet
+func IsPodOnNode(pod *v1.Pod, nodeName string) bool {
+	return nodeName != "" && GetNodeNameOfPod(pod) == nodeName
+}
+
+// GetNodeNameOfPod returns the node which the pod is running on, or has been
+// predicated to but not bound yet. It's empty if the pod is neither, or has finished.
+func GetNodeNameOfPod(pod *v1.Pod) string {
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return ""
+	}
+	if pod.Spec.NodeName != "" {
+		return pod.Spec.NodeName
+	}
+	return pod.Annotations[PredicateNode]
+}
+
+// IsReservationExpired tells if the pod has been predicated but not bound for longer
+// than ttl, a zero ttl means reservations never expire
+func IsReservationExpired(pod *v1.Pod, ttl time.Duration, now time.Time) bool {
+	if ttl <= 0 || pod.Spec.NodeName != "" {
+		return false
+	}
+	if _, ok := pod.Annotations[PredicateNode]; !ok {
+		return false
+	}
+	if _, ok := pod.Annotations[PredicateTimeAnnotation]; !ok {
+		return false
+	}
+	predicateTime, err := GetPredicateTimeOfPod(pod)
+	if err != nil {
+		klog.Infof("%v", err)
+		return false
+	}
+	return now.Sub(predicateTime) > ttl
+}
+
+// GetPredicateTimeOfPod returns when the pod was predicated according to its annotation
+func GetPredicateTimeOfPod(pod *v1.Pod) (time.Time, error) {
+	v, ok := pod.Annotations[PredicateTimeAnnotation]
+	if !ok {
+		return time.Time{}, fmt.Errorf("pod %s has no annotation %s", pod.Name,
+			PredicateTimeAnnotation)
+	}
+	predicateTime, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid annotation %s=%s of pod %s",
+			PredicateTimeAnnotation, v, pod.Name)
+	}
+	return time.Unix(0, predicateTime), nil
+}
+
+// ParseDeviceIndexes parses comma separated device indexes, like "1,3"
+func ParseDeviceIndexes(value string) ([]int, error) {
+	var ret []int
+	for _, indexStr := range strings.Split(value, ",") {
+		indexStr = strings.TrimSpace(indexStr)
+		if indexStr == "" {
+			continue
+		}
+		index, err := strconv.Atoi(indexStr)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, index)
+	}
+	return ret, nil
+}
+
 func ShouldRetry(err error) bool {
 	return apierr.IsConflict(err) || apierr.IsServerTimeout(err)
 }